@@ -8,6 +8,8 @@ package main
 import (
 	"context"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/drone-plugins/drone-gh-pages/plugin"
 
@@ -42,7 +44,14 @@ func main() {
 		logrus.SetLevel(logrus.TraceLevel)
 	}
 
-	if err := plugin.Exec(context.Background(), &args); err != nil {
+	// Cancel on termination so the plugin can clean up
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+
+	err := plugin.Exec(ctx, &args)
+
+	stop()
+
+	if err != nil {
 		logrus.Fatalln(err)
 	}
 }
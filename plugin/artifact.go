@@ -0,0 +1,522 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	deployMethodBranch   = "branch"
+	deployMethodArtifact = "artifact"
+
+	// The artifact service of GitHub Actions
+	artifactService = "/twirp/github.actions.results.api.v1.ArtifactService/"
+	artifactVersion = 4
+	artifactTar     = "artifact.tar"
+)
+
+var errDeployment = errors.New("pages deployment failed")
+
+// pagesDeployment is a deployment created through the Pages API.
+type pagesDeployment struct {
+	ID        json.Number `json:"id"`
+	StatusURL string      `json:"status_url"`
+	PageURL   string      `json:"page_url"`
+}
+
+// verifyDeployMethod checks the settings of the deploy method.
+func verifyDeployMethod(args *Args) error {
+	switch args.DeployMethod {
+	case deployMethodBranch:
+		return nil
+	case deployMethodArtifact:
+	default:
+		return fmt.Errorf("deploy_method must be %s or %s: %w", deployMethodBranch, deployMethodArtifact, errConfiguration)
+	}
+
+	if len(args.Sites) > 0 || len(args.PagesRepo.Mirrors) > 0 {
+		return fmt.Errorf("sites and mirrors require the %s deploy method: %w", deployMethodBranch, errConfiguration)
+	}
+
+	if args.Preview.Enabled || args.Versioned.Enabled {
+		return fmt.Errorf("preview and versioned require the %s deploy method: %w", deployMethodBranch, errConfiguration)
+	}
+
+	if args.Repo.Namespace == "" || args.Repo.Name == "" {
+		return fmt.Errorf("repository not known for the %s deploy method: %w", deployMethodArtifact, errConfiguration)
+	}
+
+	// Inside GitHub Actions the runner provides the artifact service
+	if args.Artifact.URL == "" {
+		args.Artifact.URL = os.Getenv("ACTIONS_RESULTS_URL")
+	}
+
+	if args.Artifact.Token == "" {
+		args.Artifact.Token = os.Getenv("ACTIONS_RUNTIME_TOKEN")
+	}
+
+	if args.DryRun {
+		return nil
+	}
+
+	if args.GitHub.Token == "" && args.GitHub.AppID == 0 {
+		return fmt.Errorf("github_token or app_id required for the %s deploy method: %w", deployMethodArtifact, errConfiguration)
+	}
+
+	if args.Artifact.URL == "" || args.Artifact.Token == "" {
+		return fmt.Errorf("artifact_url and artifact_token not specified outside GitHub Actions runners: %w", errConfiguration)
+	}
+
+	if args.Artifact.PollInterval <= 0 {
+		return fmt.Errorf("deploy_poll_interval must be positive: %w", errConfiguration)
+	}
+
+	return nil
+}
+
+// actionsRunner reports whether the artifact service, its token and the
+// oidc token of the Pages API are known, either from the environment of a
+// GitHub Actions runner or from the settings.
+func actionsRunner(args *Args) bool {
+	known := func(setting, env string) bool {
+		return setting != "" || os.Getenv(env) != ""
+	}
+
+	return known(args.Artifact.URL, "ACTIONS_RESULTS_URL") &&
+		known(args.Artifact.Token, "ACTIONS_RUNTIME_TOKEN") &&
+		known(args.Artifact.OIDCToken, "ACTIONS_ID_TOKEN_REQUEST_URL")
+}
+
+// deployArtifact publishes the pages as an artifact through the Pages API
+// and returns the URL of the deployed site.
+func deployArtifact(ctx context.Context, args *Args) (*url.URL, error) {
+	artifact, size, hash, err := packArtifact(ctx, args)
+	if artifact != "" {
+		defer os.Remove(artifact)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack artifact: %w", err)
+	}
+
+	logrus.Infof("packed %s into artifact of %d bytes\n", args.Rsync.Source, size)
+
+	if args.DryRun {
+		logrus.Infof("dry run, artifact will not be uploaded or deployed\n")
+
+		return nil, nil
+	}
+
+	id, err := uploadArtifact(ctx, args, artifact, size, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload artifact: %w", err)
+	}
+
+	logrus.Infof("uploaded artifact %s with id %d\n", args.Artifact.Name, id)
+
+	oidcToken, err := pagesOIDCToken(ctx, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request oidc token: %w", err)
+	}
+
+	deployment := pagesDeployment{}
+	body := map[string]interface{}{
+		"artifact_id":         id,
+		"pages_build_version": args.Commit.Rev,
+		"oidc_token":          oidcToken,
+		"environment":         args.Artifact.Environment,
+	}
+
+	if err := githubRequest(ctx, args, http.MethodPost, "/pages/deployments", body, &deployment); err != nil {
+		return nil, fmt.Errorf("failed to create pages deployment: %w", err)
+	}
+
+	logrus.Infof("created pages deployment %s\n", deployment.ID)
+
+	if err := waitForDeployment(ctx, args, &deployment); err != nil {
+		return nil, err
+	}
+
+	return url.Parse(deployment.PageURL)
+}
+
+// packArtifact writes a zip holding the pages as a tar archive, the layout
+// expected by the Pages API, and returns its path, size and digest.
+func packArtifact(ctx context.Context, args *Args) (string, int64, string, error) {
+	filter, err := newSyncFilter(args)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	file, err := os.CreateTemp("", "drone-gh-pages-artifact-*.zip")
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	archive := zip.NewWriter(io.MultiWriter(file, hasher))
+
+	entry, err := archive.Create(artifactTar)
+	if err != nil {
+		return file.Name(), 0, "", err
+	}
+
+	if err := tarPages(ctx, args, filter, entry); err != nil {
+		return file.Name(), 0, "", err
+	}
+
+	if err := archive.Close(); err != nil {
+		return file.Name(), 0, "", err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return file.Name(), 0, "", err
+	}
+
+	return file.Name(), info.Size(), "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// tarPages archives the contents of the source, following symlinks as the
+// Pages API does not accept them. Symlinks resolving outside the source are
+// skipped.
+func tarPages(ctx context.Context, args *Args, filter *syncFilter, out io.Writer) error {
+	root, err := filepath.EvalSymlinks(strings.TrimSuffix(args.Rsync.Source, "/"))
+	if err != nil {
+		return err
+	}
+
+	archive := tar.NewWriter(out)
+
+	if err := tarDirectory(ctx, archive, filter, root, root, "", map[string]bool{}); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// tarDirectory archives a directory under rel, descending into symlinked
+// directories within root. Directories already being archived are refused
+// so symlink loops end.
+func tarDirectory(ctx context.Context, archive *tar.Writer, filter *syncFilter, root, dir, rel string, visiting map[string]bool) error {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	if visiting[real] {
+		return fmt.Errorf("symlink loop at %s: %w", rel, errDeployment)
+	}
+
+	visiting[real] = true
+	defer delete(visiting, real)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		name := entry.Name()
+
+		if rel != "" {
+			name = rel + "/" + name
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil || !withinDirectory(root, target) {
+				logrus.Debugf("skipping symlink %s resolving outside the source\n", name)
+
+				continue
+			}
+
+			if info, err = os.Stat(target); err != nil {
+				return err
+			}
+		}
+
+		if filter.excluded(name, info.IsDir()) || (!info.IsDir() && !info.Mode().IsRegular()) {
+			continue
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+
+		header.Name = "./" + name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			err = tarDirectory(ctx, archive, filter, root, path, name, visiting)
+		} else {
+			err = tarFile(archive, path)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withinDirectory reports whether the resolved path is the directory or
+// below it.
+func withinDirectory(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func tarFile(archive *tar.Writer, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	_, err = io.Copy(archive, in)
+
+	return err
+}
+
+// uploadArtifact stores the artifact through the artifact service of the
+// workflow run and returns its id.
+func uploadArtifact(ctx context.Context, args *Args, path string, size int64, hash string) (int64, error) {
+	run, job, err := artifactBackendIDs(args.Artifact.Token)
+	if err != nil {
+		return 0, err
+	}
+
+	headers := map[string]string{
+		"Authorization": "Bearer " + args.Artifact.Token,
+	}
+
+	created := struct {
+		OK              bool   `json:"ok"`
+		SignedUploadURL string `json:"signedUploadUrl"`
+	}{}
+
+	err = requestJSON(ctx, http.MethodPost, artifactEndpoint(args, "CreateArtifact"), headers, map[string]interface{}{
+		"workflowRunBackendId":    run,
+		"workflowJobRunBackendId": job,
+		"name":                    args.Artifact.Name,
+		"version":                 artifactVersion,
+	}, &created)
+	if err != nil {
+		return 0, err
+	}
+
+	if !created.OK || created.SignedUploadURL == "" {
+		return 0, fmt.Errorf("artifact service did not accept artifact %s", args.Artifact.Name)
+	}
+
+	if err := uploadBlob(ctx, created.SignedUploadURL, path, size); err != nil {
+		return 0, err
+	}
+
+	finalized := struct {
+		OK         bool   `json:"ok"`
+		ArtifactID string `json:"artifactId"`
+	}{}
+
+	err = requestJSON(ctx, http.MethodPost, artifactEndpoint(args, "FinalizeArtifact"), headers, map[string]interface{}{
+		"workflowRunBackendId":    run,
+		"workflowJobRunBackendId": job,
+		"name":                    args.Artifact.Name,
+		"size":                    strconv.FormatInt(size, 10),
+		"hash":                    hash,
+	}, &finalized)
+	if err != nil {
+		return 0, err
+	}
+
+	if !finalized.OK {
+		return 0, fmt.Errorf("artifact service did not finalize artifact %s", args.Artifact.Name)
+	}
+
+	return strconv.ParseInt(finalized.ArtifactID, 10, 64)
+}
+
+func artifactEndpoint(args *Args, method string) string {
+	return strings.TrimSuffix(args.Artifact.URL, "/") + artifactService + method
+}
+
+// uploadBlob puts the artifact to the signed storage URL.
+func uploadBlob(ctx context.Context, endpoint, path string, size int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, file)
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/zip")
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("upload returned %s", res.Status)
+	}
+
+	return nil
+}
+
+// artifactBackendIDs reads the workflow run and job the runtime token is
+// scoped to.
+func artifactBackendIDs(token string) (string, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 { //nolint:gomnd
+		return "", "", fmt.Errorf("artifact token is not a jwt: %w", errConfiguration)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", fmt.Errorf("could not decode artifact token: %w", err)
+	}
+
+	claims := struct {
+		Scope string `json:"scp"`
+	}{}
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", "", fmt.Errorf("could not decode artifact token: %w", err)
+	}
+
+	for _, scope := range strings.Fields(claims.Scope) {
+		fields := strings.Split(scope, ":")
+		if len(fields) == 3 && fields[0] == "Actions.Results" { //nolint:gomnd
+			return fields[1], fields[2], nil
+		}
+	}
+
+	return "", "", fmt.Errorf("artifact token is not scoped to a workflow run: %w", errConfiguration)
+}
+
+// pagesOIDCToken returns the token proving the deployment comes from the
+// workflow, requesting one when it was not configured.
+func pagesOIDCToken(ctx context.Context, args *Args) (string, error) {
+	if args.Artifact.OIDCToken != "" {
+		return args.Artifact.OIDCToken, nil
+	}
+
+	endpoint := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	if endpoint == "" {
+		return "", fmt.Errorf("oidc_token not specified: %w", errConfiguration)
+	}
+
+	token := struct {
+		Value string `json:"value"`
+	}{}
+
+	headers := map[string]string{
+		"Authorization": "Bearer " + os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN"),
+	}
+
+	if err := requestJSON(ctx, http.MethodGet, endpoint, headers, nil, &token); err != nil {
+		return "", err
+	}
+
+	secrets.add(token.Value)
+
+	return token.Value, nil
+}
+
+// waitForDeployment polls the deployment until it succeeds, fails or the
+// deploy timeout passes.
+func waitForDeployment(ctx context.Context, args *Args, deployment *pagesDeployment) error {
+	ctx, cancel := phaseContext(ctx, args.Artifact.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(args.Artifact.PollInterval)
+	defer ticker.Stop()
+
+	last := ""
+
+	for {
+		status := struct {
+			Status string `json:"status"`
+		}{}
+
+		var err error
+
+		if deployment.StatusURL != "" {
+			err = requestJSON(ctx, http.MethodGet, deployment.StatusURL, githubHeaders("Bearer "+args.GitHub.Token), nil, &status)
+		} else {
+			err = githubRequest(ctx, args, http.MethodGet, "/pages/deployments/"+deployment.ID.String(), nil, &status)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to get status of pages deployment: %w", err)
+		}
+
+		if status.Status != last {
+			logrus.Infof("pages deployment %s: %s\n", deployment.ID, status.Status)
+			last = status.Status
+		}
+
+		switch status.Status {
+		case "succeed":
+			return nil
+		case "deployment_failed", "deployment_content_failed", "deployment_cancelled", "deployment_lost", "deployment_perms_error", "deployment_attempt_error":
+			return fmt.Errorf("%w: %s", errDeployment, status.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("pages deployment %s did not finish: %w", deployment.ID, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
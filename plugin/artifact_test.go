@@ -0,0 +1,411 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTarPages(t *testing.T) {
+	tests := []struct {
+		name    string
+		exclude []string
+		want    []string
+	}{
+		{
+			name: "symlinked directories are archived with their contents",
+			want: []string{"./assets/", "./assets/app.js", "./index.html", "./linked/", "./linked/app.js"},
+		},
+		{
+			name:    "excluded paths are left out",
+			exclude: []string{"*.js"},
+			want:    []string{"./assets/", "./index.html", "./linked/"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := t.TempDir()
+			writeFiles(t, src, map[string]string{
+				"index.html":    "<html></html>",
+				"assets/app.js": "app",
+			})
+
+			if err := os.Symlink(filepath.Join(src, "assets"), filepath.Join(src, "linked")); err != nil {
+				t.Fatal(err)
+			}
+
+			args := &Args{}
+			args.Rsync.Source = src + "/"
+			args.Rsync.Exclude = test.exclude
+
+			filter, err := newSyncFilter(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out bytes.Buffer
+			if err := tarPages(context.Background(), args, filter, &out); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := tarNames(t, &out); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("archived %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTarPagesSymlinkLoop(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"sub/index.html": ""})
+
+	if err := os.Symlink(src, filepath.Join(src, "sub", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	args := &Args{}
+	args.Rsync.Source = src + "/"
+
+	filter, err := newSyncFilter(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tarPages(context.Background(), args, filter, io.Discard); !errors.Is(err, errDeployment) {
+		t.Errorf("got %v, want %v", err, errDeployment)
+	}
+}
+
+func TestTarPagesSymlinkOutside(t *testing.T) {
+	outside := t.TempDir()
+	writeFiles(t, outside, map[string]string{"secret.txt": "secret"})
+
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"index.html": ""})
+
+	links := map[string]string{
+		"directory": outside,
+		"file":      filepath.Join(outside, "secret.txt"),
+		"dangling":  filepath.Join(outside, "missing"),
+		"index.htm": filepath.Join(src, "index.html"),
+	}
+
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(src, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args := &Args{}
+	args.Rsync.Source = src + "/"
+
+	filter, err := newSyncFilter(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := tarPages(context.Background(), args, filter, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := tarNames(t, &out), []string{"./index.htm", "./index.html"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("archived %v, want %v", got, want)
+	}
+}
+
+func TestActionsRunner(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		settings bool
+		want     bool
+	}{
+		{
+			name: "outside a runner",
+		},
+		{
+			name: "inside a runner",
+			env: map[string]string{
+				"ACTIONS_RESULTS_URL":          "https://results.example.com",
+				"ACTIONS_RUNTIME_TOKEN":        "token",
+				"ACTIONS_ID_TOKEN_REQUEST_URL": "https://token.example.com",
+			},
+			want: true,
+		},
+		{
+			name: "runner without id-token permission",
+			env: map[string]string{
+				"ACTIONS_RESULTS_URL":   "https://results.example.com",
+				"ACTIONS_RUNTIME_TOKEN": "token",
+			},
+		},
+		{
+			name:     "artifact settings",
+			settings: true,
+			want:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"ACTIONS_RESULTS_URL", "ACTIONS_RUNTIME_TOKEN", "ACTIONS_ID_TOKEN_REQUEST_URL"} {
+				t.Setenv(name, test.env[name])
+			}
+
+			args := &Args{}
+			args.DeployMethod = deployMethodArtifact
+
+			if test.settings {
+				args.Artifact.URL = "https://results.example.com"
+				args.Artifact.Token = "token"
+				args.Artifact.OIDCToken = "oidc"
+			}
+
+			if got := actionsRunner(args); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+
+			_, warnings := lintArgs(args)
+			if linted := strings.Contains(warnings, "GitHub Actions runners"); linted == test.want {
+				t.Errorf("got runner warning %t, want %t", linted, !test.want)
+			}
+		})
+	}
+}
+
+func TestDeployArtifact(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []string
+		want     string
+		err      error
+	}{
+		{
+			name:     "deployment succeeds",
+			statuses: []string{"deployment_queued", "syncing_files", "succeed"},
+			want:     "https://octo.github.io/pages/",
+		},
+		{
+			name:     "deployment fails",
+			statuses: []string{"deployment_queued", "deployment_failed"},
+			err:      errDeployment,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := t.TempDir()
+			writeFiles(t, src, map[string]string{"index.html": "<html></html>"})
+
+			api := &pagesAPI{t: t, statuses: test.statuses}
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			api.url = server.URL
+
+			args := &Args{}
+			args.Rsync.Source = src + "/"
+			args.Repo.Namespace = "octo"
+			args.Repo.Name = "pages"
+			args.Commit.Rev = "0123456789abcdef"
+			args.GitHub.API = server.URL
+			args.GitHub.Token = "github-token"
+			args.Artifact.Name = "github-pages"
+			args.Artifact.URL = server.URL
+			args.Artifact.Token = artifactToken("Actions.Results:run-1:job-2")
+			args.Artifact.OIDCToken = "oidc-token"
+			args.Artifact.Environment = "github-pages"
+			args.Artifact.PollInterval = time.Millisecond
+			args.Artifact.Timeout = 10 * time.Second
+
+			site, err := deployArtifact(context.Background(), args)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+
+			if test.err != nil {
+				return
+			}
+
+			if site.String() != test.want {
+				t.Errorf("got url %s, want %s", site, test.want)
+			}
+
+			if api.deployment["artifact_id"] != float64(4242) || api.deployment["oidc_token"] != "oidc-token" {
+				t.Errorf("unexpected pages deployment %v", api.deployment)
+			}
+
+			if got := tarNames(t, bytes.NewReader(api.tarball)); !reflect.DeepEqual(got, []string{"./index.html"}) {
+				t.Errorf("uploaded %v", got)
+			}
+		})
+	}
+}
+
+func TestArtifactBackendIDs(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		run   string
+		job   string
+		err   error
+	}{
+		{
+			name:  "scoped token",
+			token: artifactToken("Actions.GenericRead:1 Actions.Results:run-1:job-2"),
+			run:   "run-1",
+			job:   "job-2",
+		},
+		{
+			name:  "token without results scope",
+			token: artifactToken("Actions.GenericRead:1"),
+			err:   errConfiguration,
+		},
+		{
+			name:  "not a jwt",
+			token: "token",
+			err:   errConfiguration,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run, job, err := artifactBackendIDs(test.token)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+
+			if run != test.run || job != test.job {
+				t.Errorf("got %s/%s, want %s/%s", run, job, test.run, test.job)
+			}
+		})
+	}
+}
+
+// pagesAPI stands in for the artifact service and the Pages API.
+type pagesAPI struct {
+	t          *testing.T
+	url        string
+	statuses   []string
+	blob       []byte
+	tarball    []byte
+	deployment map[string]interface{}
+}
+
+func (a *pagesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	request := map[string]interface{}{}
+	_ = json.Unmarshal(body, &request)
+
+	switch {
+	case r.URL.Path == artifactService+"CreateArtifact":
+		if request["workflowRunBackendId"] != "run-1" || request["workflowJobRunBackendId"] != "job-2" {
+			a.t.Errorf("artifact created for %v", request)
+		}
+
+		writeJSON(w, map[string]interface{}{"ok": true, "signedUploadUrl": a.url + "/blob"})
+	case r.URL.Path == "/blob" && r.Method == http.MethodPut:
+		a.blob = body
+		a.tarball = unzipEntry(a.t, body, artifactTar)
+		w.WriteHeader(http.StatusCreated)
+	case r.URL.Path == artifactService+"FinalizeArtifact":
+		sum := sha256.Sum256(a.blob)
+		if request["hash"] != "sha256:"+hex.EncodeToString(sum[:]) {
+			a.t.Errorf("finalized with hash %v", request["hash"])
+		}
+
+		writeJSON(w, map[string]interface{}{"ok": true, "artifactId": "4242"})
+	case r.URL.Path == "/repos/octo/pages/pages/deployments" && r.Method == http.MethodPost:
+		if r.Header.Get("Authorization") != "Bearer github-token" {
+			a.t.Errorf("deployment created with %s", r.Header.Get("Authorization"))
+		}
+
+		a.deployment = request
+		writeJSON(w, map[string]interface{}{"id": 77, "page_url": "https://octo.github.io/pages/"})
+	case r.URL.Path == "/repos/octo/pages/pages/deployments/77":
+		status := a.statuses[0]
+		if len(a.statuses) > 1 {
+			a.statuses = a.statuses[1:]
+		}
+
+		writeJSON(w, map[string]string{"status": status})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// artifactToken returns a runtime token carrying the scope.
+func artifactToken(scope string) string {
+	payload, _ := json.Marshal(map[string]string{"scp": scope})
+
+	return "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".c2ln"
+}
+
+func unzipEntry(t *testing.T, data []byte, name string) []byte {
+	t.Helper()
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := archive.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer entry.Close()
+
+	content, err := io.ReadAll(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return content
+}
+
+func tarNames(t *testing.T, in io.Reader) []string {
+	t.Helper()
+
+	names := []string{}
+	archive := tar.NewReader(in)
+
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names = append(names, header.Name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
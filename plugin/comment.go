@@ -0,0 +1,143 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Comments list at most this many changed files
+	commentMaxChanges = 50
+
+	commentsPerPage = 100
+)
+
+// issueComment is a comment on the pull request.
+type issueComment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+// commentMarker identifies the comment of the preview so later builds
+// update it in place.
+func commentMarker(args *Args) string {
+	return fmt.Sprintf("<!-- drone-gh-pages preview %s -->", filepath.ToSlash(args.TargetDirectory))
+}
+
+// commentPreview posts the preview url and the changes on the pull request,
+// updating the comment of an earlier build. Failures are logged as the
+// comment only informs.
+func commentPreview(ctx context.Context, args *Args, preview string) {
+	if !args.Comment.Enabled || args.DryRun || preview == "" {
+		return
+	}
+
+	// App tokens are only minted once publishing starts
+	if args.GitHub.Token == "" {
+		logrus.Warningf("no github token to comment on pull request %d\n", args.PullRequest.Number)
+
+		return
+	}
+
+	ctx, cancel := phaseContext(ctx, args.Timeout.Fetch)
+	defer cancel()
+
+	body := previewComment(args, preview)
+
+	existing, err := findComment(ctx, args, commentMarker(args))
+	if err != nil {
+		logrus.Warningf("could not list comments of pull request %d: %s\n", args.PullRequest.Number, err)
+
+		return
+	}
+
+	if existing != nil {
+		err = githubRequest(ctx, args, http.MethodPatch, fmt.Sprintf("/issues/comments/%d", existing.ID), map[string]string{"body": body}, nil)
+	} else {
+		err = githubRequest(ctx, args, http.MethodPost, fmt.Sprintf("/issues/%d/comments", args.PullRequest.Number), map[string]string{"body": body}, nil)
+	}
+
+	if err != nil {
+		logrus.Warningf("could not comment on pull request %d: %s\n", args.PullRequest.Number, err)
+
+		return
+	}
+
+	logrus.Infof("commented preview on pull request %d\n", args.PullRequest.Number)
+}
+
+// findComment returns the comment holding the marker, if any.
+func findComment(ctx context.Context, args *Args, marker string) (*issueComment, error) {
+	for page := 1; ; page++ {
+		comments := []issueComment{}
+		path := fmt.Sprintf("/issues/%d/comments?per_page=%d&page=%d", args.PullRequest.Number, commentsPerPage, page)
+
+		if err := githubRequest(ctx, args, http.MethodGet, path, nil, &comments); err != nil {
+			return nil, err
+		}
+
+		for i := range comments {
+			if strings.Contains(comments[i].Body, marker) {
+				return &comments[i], nil
+			}
+		}
+
+		if len(comments) < commentsPerPage {
+			return nil, nil
+		}
+	}
+}
+
+// previewComment renders the comment with the preview url, the pages
+// commit and the files the publish changed.
+func previewComment(args *Args, preview string) string {
+	var comment strings.Builder
+
+	comment.WriteString(commentMarker(args) + "\n")
+	comment.WriteString("### Preview published\n\n")
+	comment.WriteString(fmt.Sprintf("- **Preview:** %s\n", preview))
+
+	if rev := args.Comment.Rev; rev != "" {
+		short := rev
+		if len(short) > 7 { //nolint:gomnd
+			short = short[:7]
+		}
+
+		comment.WriteString(fmt.Sprintf("- **Pages commit:** `%s` on `%s`\n", short, args.PagesRepo.Branch))
+	}
+
+	plan := newPlan(args, args.Comment.Changes)
+	if len(plan.Changes) == 0 {
+		comment.WriteString("\nNo files changed.\n")
+
+		return comment.String()
+	}
+
+	comment.WriteString(fmt.Sprintf("\n<details>\n<summary>%d file(s) changed: %d added, %d modified, %d deleted (%+d bytes)</summary>\n\n",
+		len(plan.Changes), plan.Added, plan.Modified, plan.Deleted, plan.Delta))
+	comment.WriteString("| Status | File | Size |\n| --- | --- | --- |\n")
+
+	for i, change := range plan.Changes {
+		if i == commentMaxChanges {
+			comment.WriteString(fmt.Sprintf("\nand %d more\n", len(plan.Changes)-commentMaxChanges))
+
+			break
+		}
+
+		comment.WriteString(fmt.Sprintf("| %s | `%s` | %+d bytes |\n", change.Status, change.Path, change.NewSize-change.OldSize))
+	}
+
+	comment.WriteString("\n</details>\n")
+
+	return comment.String()
+}
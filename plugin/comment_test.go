@@ -0,0 +1,187 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCommentPreview(t *testing.T) {
+	tests := []struct {
+		name     string
+		comments []issueComment
+		want     []string
+	}{
+		{
+			name:     "first build posts a comment",
+			comments: []issueComment{{ID: 1, Body: "looks good"}},
+			want: []string{
+				"GET /issues/7/comments page 1",
+				"POST /issues/7/comments",
+			},
+		},
+		{
+			name:     "later builds update the comment",
+			comments: append(otherComments(commentsPerPage), issueComment{ID: 500, Body: "<!-- drone-gh-pages preview pr-7 -->\nold"}),
+			want: []string{
+				"GET /issues/7/comments page 1",
+				"GET /issues/7/comments page 2",
+				"PATCH /issues/comments/500",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &commentsAPI{comments: test.comments, calls: []string{}}
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			args := &Args{}
+			args.GitHub.API = server.URL
+			args.GitHub.Token = "token"
+			args.Repo.Namespace = "octo"
+			args.Repo.Name = "pages"
+			args.PullRequest.Number = 7
+			args.TargetDirectory = "pr-7"
+			args.Comment.Enabled = true
+
+			commentPreview(context.Background(), args, "https://octo.github.io/pages/pr-7/")
+
+			if !reflect.DeepEqual(api.calls, test.want) {
+				t.Errorf("got calls %q, want %q", api.calls, test.want)
+			}
+
+			if !strings.HasPrefix(api.body, commentMarker(args)) || !strings.Contains(api.body, "https://octo.github.io/pages/pr-7/") {
+				t.Errorf("unexpected comment %q", api.body)
+			}
+		})
+	}
+}
+
+func TestPreviewComment(t *testing.T) {
+	tests := []struct {
+		name    string
+		rev     string
+		changes []fileChange
+		want    []string
+	}{
+		{
+			name: "no changes",
+			want: []string{"- **Preview:** https://example.com/pr-7/", "No files changed."},
+		},
+		{
+			name: "changes are listed",
+			rev:  "0123456789abcdef",
+			changes: []fileChange{
+				{Path: "pr-7/index.html", Status: changeModified, OldSize: 10, NewSize: 15},
+				{Path: "pr-7/app.js", Status: changeAdded, NewSize: 20},
+			},
+			want: []string{
+				"- **Pages commit:** `0123456` on `gh-pages`",
+				"2 file(s) changed: 1 added, 1 modified, 0 deleted (+25 bytes)",
+				"| added | `pr-7/app.js` | +20 bytes |\n| modified | `pr-7/index.html` | +5 bytes |",
+			},
+		},
+		{
+			name:    "long change lists are cut",
+			changes: manyChanges(commentMaxChanges + 3),
+			want:    []string{"and 3 more"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.TargetDirectory = "pr-7"
+			args.PagesRepo.Branch = "gh-pages"
+			args.Comment.Rev = test.rev
+			args.Comment.Changes = test.changes
+
+			got := previewComment(args, "https://example.com/pr-7/")
+
+			for _, want := range test.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("comment %q does not contain %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+// commentsAPI stands in for the issue comments API and records the calls.
+type commentsAPI struct {
+	mu       sync.Mutex
+	comments []issueComment
+	calls    []string
+	body     string
+}
+
+func (a *commentsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/repos/octo/pages")
+
+	if r.Method == http.MethodGet {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		a.calls = append(a.calls, fmt.Sprintf("GET %s page %d", path, page))
+
+		start := (page - 1) * perPage
+		end := start + perPage
+
+		switch {
+		case start > len(a.comments):
+			start, end = 0, 0
+		case end > len(a.comments):
+			end = len(a.comments)
+		}
+
+		writeJSON(w, a.comments[start:end])
+
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	comment := issueComment{}
+	_ = json.Unmarshal(body, &comment)
+
+	a.calls = append(a.calls, fmt.Sprintf("%s %s", r.Method, path))
+	a.body = comment.Body
+
+	writeJSON(w, issueComment{ID: 1, Body: comment.Body})
+}
+
+func otherComments(count int) []issueComment {
+	comments := []issueComment{}
+
+	for i := 1; i <= count; i++ {
+		comments = append(comments, issueComment{ID: int64(i), Body: "comment"})
+	}
+
+	return comments
+}
+
+func manyChanges(count int) []fileChange {
+	changes := []fileChange{}
+
+	for i := 0; i < count; i++ {
+		changes = append(changes, fileChange{Path: fmt.Sprintf("pr-7/%03d.html", i), Status: changeAdded, NewSize: 1})
+	}
+
+	return changes
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	deploymentInProgress = "in_progress"
+	deploymentSuccess    = "success"
+	deploymentFailure    = "failure"
+	deploymentInactive   = "inactive"
+
+	pagesEnvironment   = "github-pages"
+	previewEnvironment = "preview-"
+)
+
+// githubDeployment is the deployment record of a publish shown in the
+// environments of the repository.
+type githubDeployment struct {
+	args *Args
+	ID   int64 `json:"id"`
+}
+
+// recordsDeployments reports whether publishes create deployment records.
+// Artifact deployments are recorded by the Pages API itself.
+func recordsDeployments(args *Args) bool {
+	return args.Deployment.Enabled && !args.DryRun && args.DeployMethod != deployMethodArtifact
+}
+
+// deploymentEnvironment returns the environment the pages are deployed to.
+func deploymentEnvironment(args *Args) string {
+	switch {
+	case args.Deployment.Environment != "":
+		return args.Deployment.Environment
+	case args.Preview.Directory != "":
+		return previewEnvironment + args.Preview.Directory
+	}
+
+	return pagesEnvironment
+}
+
+// createDeployment records a deployment of the commit being built and
+// marks it in progress. Closed previews have their deployments marked
+// inactive instead. Failures are logged as deployments only inform.
+func createDeployment(ctx context.Context, args *Args) *githubDeployment {
+	if !recordsDeployments(args) {
+		return nil
+	}
+
+	environment := deploymentEnvironment(args)
+
+	// App tokens are only minted once publishing starts
+	if args.GitHub.Token == "" {
+		logrus.Warningf("no github token to record deployment for %s\n", environment)
+
+		return nil
+	}
+
+	ctx, cancel := phaseContext(ctx, args.Timeout.Fetch)
+	defer cancel()
+
+	if args.Preview.Cleanup {
+		if err := inactivateDeployments(ctx, args, environment, 0); err != nil {
+			logrus.Warningf("could not mark deployments of %s inactive: %s\n", environment, err)
+		}
+
+		return nil
+	}
+
+	preview := args.Preview.Directory != ""
+	deployment := &githubDeployment{args: args}
+
+	err := githubRequest(ctx, args, http.MethodPost, "/deployments", map[string]interface{}{
+		"ref":                    args.Commit.Rev,
+		"environment":            environment,
+		"description":            fmt.Sprintf("pages published to %s", args.PagesRepo.Branch),
+		"auto_merge":             false,
+		"required_contexts":      []string{},
+		"transient_environment":  preview,
+		"production_environment": !preview,
+	}, deployment)
+	if err != nil {
+		logrus.Warningf("could not create deployment for %s: %s\n", environment, err)
+
+		return nil
+	}
+
+	logrus.Infof("created deployment %d for %s\n", deployment.ID, environment)
+
+	if err := deployment.status(ctx, deploymentInProgress, ""); err != nil {
+		logrus.Warningf("could not update deployment %d: %s\n", deployment.ID, err)
+	}
+
+	return deployment
+}
+
+// finish marks the deployment successful at the url or failed when the
+// publish failed. Older deployments of a preview are marked inactive.
+func (d *githubDeployment) finish(ctx context.Context, environmentURL string, failure error) {
+	if d == nil {
+		return
+	}
+
+	ctx, cancel := phaseContext(ctx, d.args.Timeout.Fetch)
+	defer cancel()
+
+	state := deploymentSuccess
+	if failure != nil {
+		state = deploymentFailure
+	}
+
+	if err := d.status(ctx, state, environmentURL); err != nil {
+		logrus.Warningf("could not update deployment %d: %s\n", d.ID, err)
+
+		return
+	}
+
+	logrus.Infof("deployment %d: %s\n", d.ID, state)
+
+	if state == deploymentSuccess && d.args.Preview.Directory != "" {
+		environment := deploymentEnvironment(d.args)
+
+		if err := inactivateDeployments(ctx, d.args, environment, d.ID); err != nil {
+			logrus.Warningf("could not mark deployments of %s inactive: %s\n", environment, err)
+		}
+	}
+}
+
+func (d *githubDeployment) status(ctx context.Context, state, environmentURL string) error {
+	body := map[string]interface{}{
+		"state": state,
+	}
+
+	if environmentURL != "" {
+		body["environment_url"] = environmentURL
+	}
+
+	if d.args.Build.Link != "" {
+		body["log_url"] = d.args.Build.Link
+	}
+
+	return githubRequest(ctx, d.args, http.MethodPost, fmt.Sprintf("/deployments/%d/statuses", d.ID), body, nil)
+}
+
+// inactivateDeployments marks the deployments of the environment other
+// than current inactive.
+func inactivateDeployments(ctx context.Context, args *Args, environment string, current int64) error {
+	deployments := []githubDeployment{}
+
+	query := url.Values{"environment": {environment}, "per_page": {"100"}}
+	if err := githubRequest(ctx, args, http.MethodGet, "/deployments?"+query.Encode(), nil, &deployments); err != nil {
+		return err
+	}
+
+	for i := range deployments {
+		deployment := &deployments[i]
+		if deployment.ID == current {
+			continue
+		}
+
+		deployment.args = args
+		if err := deployment.status(ctx, deploymentInactive, ""); err != nil {
+			return err
+		}
+
+		logrus.Infof("deployment %d: %s\n", deployment.ID, deploymentInactive)
+	}
+
+	return nil
+}
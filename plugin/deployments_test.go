@@ -0,0 +1,182 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDeploymentEnvironment(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment string
+		preview     string
+		want        string
+	}{
+		{
+			name: "pages",
+			want: "github-pages",
+		},
+		{
+			name:    "preview",
+			preview: "pr-7",
+			want:    "preview-pr-7",
+		},
+		{
+			name:        "configured environment",
+			environment: "docs",
+			preview:     "pr-7",
+			want:        "docs",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.Deployment.Environment = test.environment
+			args.Preview.Directory = test.preview
+
+			if got := deploymentEnvironment(args); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDeployments(t *testing.T) {
+	tests := []struct {
+		name     string
+		preview  string
+		cleanup  bool
+		dryRun   bool
+		failure  error
+		existing []int64
+		want     []string
+	}{
+		{
+			name: "successful publish",
+			want: []string{
+				"POST /deployments github-pages",
+				"POST /deployments/10/statuses in_progress",
+				"POST /deployments/10/statuses success https://octo.github.io/pages/",
+			},
+		},
+		{
+			name:    "failed publish",
+			failure: errors.New("push failed"),
+			want: []string{
+				"POST /deployments github-pages",
+				"POST /deployments/10/statuses in_progress",
+				"POST /deployments/10/statuses failure https://octo.github.io/pages/",
+			},
+		},
+		{
+			name:     "preview replaces earlier deployments",
+			preview:  "pr-7",
+			existing: []int64{3, 10},
+			want: []string{
+				"POST /deployments preview-pr-7",
+				"POST /deployments/10/statuses in_progress",
+				"POST /deployments/10/statuses success https://octo.github.io/pages/",
+				"GET /deployments preview-pr-7",
+				"POST /deployments/3/statuses inactive",
+			},
+		},
+		{
+			name:     "closed preview",
+			preview:  "pr-7",
+			cleanup:  true,
+			existing: []int64{3, 4},
+			want: []string{
+				"GET /deployments preview-pr-7",
+				"POST /deployments/3/statuses inactive",
+				"POST /deployments/4/statuses inactive",
+			},
+		},
+		{
+			name:   "dry run",
+			dryRun: true,
+			want:   []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := &deploymentsAPI{existing: test.existing, calls: []string{}}
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			args := &Args{}
+			args.GitHub.API = server.URL
+			args.GitHub.Token = "token"
+			args.Repo.Namespace = "octo"
+			args.Repo.Name = "pages"
+			args.Commit.Rev = "0123456789abcdef"
+			args.Deployment.Enabled = true
+			args.DeployMethod = deployMethodBranch
+			args.DryRun = test.dryRun
+			args.Preview.Directory = test.preview
+			args.Preview.Cleanup = test.cleanup
+
+			deployment := createDeployment(context.Background(), args)
+			deployment.finish(context.Background(), "https://octo.github.io/pages/", test.failure)
+
+			if !reflect.DeepEqual(api.calls, test.want) {
+				t.Errorf("got calls %q, want %q", api.calls, test.want)
+			}
+		})
+	}
+}
+
+// deploymentsAPI stands in for the deployments API and records the calls.
+type deploymentsAPI struct {
+	mu       sync.Mutex
+	existing []int64
+	calls    []string
+}
+
+func (a *deploymentsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	request := map[string]interface{}{}
+	_ = json.Unmarshal(body, &request)
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/repos/octo/pages/deployments":
+		a.calls = append(a.calls, fmt.Sprintf("POST /deployments %s", request["environment"]))
+		writeJSON(w, map[string]int64{"id": 10})
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/octo/pages/deployments":
+		a.calls = append(a.calls, fmt.Sprintf("GET /deployments %s", r.URL.Query().Get("environment")))
+
+		deployments := []map[string]int64{}
+		for _, id := range a.existing {
+			deployments = append(deployments, map[string]int64{"id": id})
+		}
+
+		writeJSON(w, deployments)
+	case r.Method == http.MethodPost:
+		call := fmt.Sprintf("POST %s %s", r.URL.Path[len("/repos/octo/pages"):], request["state"])
+		if uri, ok := request["environment_url"]; ok {
+			call += fmt.Sprintf(" %s", uri)
+		}
+
+		a.calls = append(a.calls, call)
+		writeJSON(w, map[string]int64{"id": 1})
+	default:
+		http.NotFound(w, r)
+	}
+}
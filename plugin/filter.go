@@ -0,0 +1,387 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const ignoreFile = ".ghpagesignore"
+
+// syncRule is a gitignore style pattern.
+type syncRule struct {
+	raw      string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+	pattern  *regexp.Regexp
+}
+
+// syncFilter decides which paths take part in the sync. Paths are slash
+// separated and relative to the pages directory.
+type syncFilter struct {
+	hard     []string
+	keep     []syncRule
+	protect  []syncRule
+	excludes []syncRule
+	includes []syncRule
+}
+
+func newSyncFilter(args *Args) (*syncFilter, error) {
+	filter := &syncFilter{
+		hard: []string{".git", ignoreFile},
+	}
+
+	if args.Rsync.ExcludeCname {
+		filter.hard = append(filter.hard, "CNAME")
+	}
+
+	keep, err := parseSyncRules(args.Rsync.Keep)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keep: %w", err)
+	}
+
+	filter.keep = keep
+
+	// Seed files sit next to the pages when syncing into the branch root,
+	// they are overwritten by the pages but never deleted
+	if strings.HasSuffix(args.Rsync.Source, "/") && filepath.Clean(args.TargetDirectory) == "." {
+		for _, seed := range args.PagesRepo.Seed {
+			rule, err := parseSyncRule("/" + filepath.Base(seed))
+			if err != nil {
+				return nil, fmt.Errorf("invalid seed %s: %w", seed, err)
+			}
+
+			filter.protect = append(filter.protect, rule)
+		}
+	}
+
+	for _, pattern := range args.Rsync.Exclude {
+		rule, err := parseSyncRule(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude %s: %w", pattern, err)
+		}
+
+		filter.excludes = append(filter.excludes, rule)
+	}
+
+	for _, pattern := range args.Rsync.Include {
+		rule, err := parseSyncRule(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include %s: %w", pattern, err)
+		}
+
+		filter.includes = append(filter.includes, rule)
+	}
+
+	rules, err := readIgnoreFile(filepath.Join(strings.TrimSuffix(args.Rsync.Source, "/"), ignoreFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", ignoreFile, err)
+	}
+
+	filter.excludes = append(filter.excludes, rules...)
+
+	return filter, nil
+}
+
+func readIgnoreFile(name string) ([]syncRule, error) {
+	file, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules := []syncRule{}
+	scanner := bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		rule, err := parseSyncRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, scanner.Err()
+}
+
+func parseSyncRules(patterns []string) ([]syncRule, error) {
+	rules := []syncRule{}
+
+	for _, pattern := range patterns {
+		rule, err := parseSyncRule(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func parseSyncRule(pattern string) (syncRule, error) {
+	rule := syncRule{raw: pattern}
+
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	// A slash at the start or in the middle anchors the pattern
+	if strings.Contains(pattern, "/") {
+		rule.anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+
+	if pattern == "" {
+		return rule, fmt.Errorf("empty pattern: %w", errConfiguration)
+	}
+
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return rule, err
+	}
+
+	rule.pattern = re
+
+	return rule, nil
+}
+
+func (r *syncRule) match(rel string, dir bool) bool {
+	if r.dirOnly && !dir {
+		return false
+	}
+
+	if r.anchored {
+		return r.pattern.MatchString(rel)
+	}
+
+	return r.pattern.MatchString(path.Base(rel))
+}
+
+// rsync returns the pattern in rsync syntax, anchored under prefix.
+func (r *syncRule) rsync(prefix string) string {
+	pattern := strings.TrimPrefix(r.raw, "!")
+
+	if r.anchored {
+		pattern = "/" + path.Join(prefix, strings.TrimPrefix(pattern, "/"))
+		if r.dirOnly {
+			pattern += "/"
+		}
+	}
+
+	return pattern
+}
+
+// excluded reports whether the path is left out of the sync. The last
+// matching exclude rule wins, as in gitignore.
+func (f *syncFilter) excluded(rel string, dir bool) bool {
+	base := path.Base(rel)
+
+	for _, name := range f.hard {
+		if base == name {
+			return true
+		}
+	}
+
+	// Kept paths belong to the destination and are never touched
+	for i := range f.keep {
+		if f.keep[i].match(rel, dir) {
+			return true
+		}
+	}
+
+	excluded := false
+
+	for i := range f.excludes {
+		if f.excludes[i].match(rel, dir) {
+			excluded = !f.excludes[i].negate
+		}
+	}
+
+	if excluded || dir || len(f.includes) == 0 {
+		return excluded
+	}
+
+	for i := range f.includes {
+		if f.includes[i].match(rel, dir) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// protected reports whether the path is never deleted from the
+// destination, even when it is not in the pages.
+func (f *syncFilter) protected(rel string, dir bool) bool {
+	for i := range f.protect {
+		if f.protect[i].match(rel, dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rsyncArgs translates the filter into rsync arguments. Rsync uses the
+// first matching rule so the exclude rules are reversed.
+func (f *syncFilter) rsyncArgs(prefix string) []string {
+	args := []string{}
+
+	for _, name := range f.hard {
+		args = append(args, "--exclude", name)
+	}
+
+	for i := range f.keep {
+		args = append(args, "--exclude", f.keep[i].rsync(prefix))
+	}
+
+	for i := range f.protect {
+		args = append(args, "--filter", "P "+f.protect[i].rsync(prefix))
+	}
+
+	for i := len(f.excludes) - 1; i >= 0; i-- {
+		sign := "-"
+		if f.excludes[i].negate {
+			sign = "+"
+		}
+
+		args = append(args, "--filter", sign+" "+f.excludes[i].rsync(prefix))
+	}
+
+	if len(f.includes) > 0 {
+		args = append(args, "--include", "*/")
+
+		for i := range f.includes {
+			args = append(args, "--include", f.includes[i].rsync(prefix))
+		}
+
+		args = append(args, "--exclude", "*")
+	}
+
+	return args
+}
+
+// keepConflicts returns the paths in the pages directory matching a keep
+// rule, these are never published.
+func keepConflicts(args *Args) []string {
+	rules, err := parseSyncRules(args.Rsync.Keep)
+	if err != nil || len(rules) == 0 {
+		return nil
+	}
+
+	src := args.PagesDirectory
+	if src == "" {
+		src = "docs"
+	}
+
+	conflicts := []string{}
+
+	_ = filepath.WalkDir(src, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil //nolint:nilerr
+		}
+
+		rel, err := filepath.Rel(src, name)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		for i := range rules {
+			if rules[i].match(rel, d.IsDir()) {
+				conflicts = append(conflicts, rel)
+
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return conflicts
+}
+
+// globRegexp converts a glob supporting ** into a regular expression.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var builder strings.Builder
+
+	builder.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(glob[i:], "**/"):
+				builder.WriteString("(?:.*/)?")
+				i += 2
+			case strings.HasPrefix(glob[i:], "**"):
+				builder.WriteString(".*")
+				i++
+			default:
+				builder.WriteString("[^/]*")
+			}
+		case '?':
+			builder.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				builder.WriteString(`\[`)
+
+				continue
+			}
+
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			builder.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				builder.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	builder.WriteString("$")
+
+	return regexp.Compile(builder.String())
+}
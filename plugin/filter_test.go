@@ -0,0 +1,184 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSyncFilterExcluded(t *testing.T) {
+	tests := []struct {
+		name     string
+		exclude  []string
+		include  []string
+		keep     []string
+		ignore   string
+		excluded map[string]bool
+	}{
+		{
+			name: "git metadata and the ignore file are never synced",
+			excluded: map[string]bool{
+				".git":           true,
+				"sub/.git":       true,
+				".ghpagesignore": true,
+				"index.html":     false,
+			},
+		},
+		{
+			name:    "later rules override earlier ones",
+			exclude: []string{"*.map", "!keep.map"},
+			excluded: map[string]bool{
+				"app.js.map":    true,
+				"js/app.js.map": true,
+				"keep.map":      false,
+				"app.js":        false,
+			},
+		},
+		{
+			name:    "anchored and directory rules",
+			exclude: []string{"/drafts/", "tmp/"},
+			excluded: map[string]bool{
+				"drafts/":        true,
+				"posts/drafts/":  false,
+				"tmp/":           true,
+				"posts/tmp/":     true,
+				"tmp":            false,
+				"drafts/old.txt": false,
+			},
+		},
+		{
+			name:    "includes limit the files but not the directories",
+			include: []string{"*.html", "/assets/**"},
+			excluded: map[string]bool{
+				"index.html":     false,
+				"guide/":         false,
+				"guide/a.html":   false,
+				"assets/app.css": false,
+				"notes.txt":      true,
+			},
+		},
+		{
+			name: "kept paths are left alone",
+			keep: []string{"/CNAME", "archive/"},
+			excluded: map[string]bool{
+				"CNAME":      true,
+				"sub/CNAME":  false,
+				"archive/":   true,
+				"index.html": false,
+			},
+		},
+		{
+			name:   "ignore file rules",
+			ignore: "# comment\n\n*.psd\n/src/\n",
+			excluded: map[string]bool{
+				"logo.psd":   true,
+				"src/":       true,
+				"img/src/":   false,
+				"index.html": false,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := t.TempDir()
+			if test.ignore != "" {
+				writeFiles(t, src, map[string]string{ignoreFile: test.ignore})
+			}
+
+			args := &Args{}
+			args.Rsync.Source = src + "/"
+			args.Rsync.Exclude = test.exclude
+			args.Rsync.Include = test.include
+			args.Rsync.Keep = test.keep
+
+			filter, err := newSyncFilter(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for rel, want := range test.excluded {
+				dir := rel[len(rel)-1] == '/'
+				if dir {
+					rel = rel[:len(rel)-1]
+				}
+
+				if got := filter.excluded(rel, dir); got != want {
+					t.Errorf("excluded(%s, %t) = %t, want %t", rel, dir, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncFilterRsyncArgs(t *testing.T) {
+	args := &Args{}
+	args.Rsync.Source = t.TempDir() + "/"
+	args.Rsync.Exclude = []string{"*.map", "!keep.map", "/drafts/"}
+	args.Rsync.Keep = []string{"/CNAME"}
+
+	filter, err := newSyncFilter(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"--exclude", ".git",
+		"--exclude", ".ghpagesignore",
+		"--exclude", "/docs/CNAME",
+		"--filter", "- /docs/drafts/",
+		"--filter", "+ keep.map",
+		"--filter", "- *.map",
+	}
+
+	if got := filter.rsyncArgs("docs"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestParseSyncRule(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{pattern: "*.html"},
+		{pattern: "!/docs/**/*.md"},
+		{pattern: "/", wantErr: true},
+		{pattern: "!", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			if _, err := parseSyncRule(test.pattern); (err != nil) != test.wantErr {
+				t.Errorf("got error %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeepConflicts(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"CNAME":      "example.com",
+		"index.html": "",
+	})
+
+	if err := os.Mkdir(filepath.Join(src, "archive"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	args := &Args{}
+	args.PagesDirectory = src
+	args.Rsync.Source = src
+	args.Rsync.Keep = []string{"/CNAME", "archive/"}
+
+	if got := keepConflicts(args); !reflect.DeepEqual(got, []string{"CNAME", "archive"}) {
+		t.Errorf("got %v", got)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	engineCLI = "cli"
+	engineGo  = "go"
+
+	historyAppend   = "append"
+	historyAmend    = "amend"
+	historySingle   = "single"
+	historyKeepLast = "keep-last-"
+)
+
+// gitEngine performs the git operations on the pages checkout.
+type gitEngine interface {
+	// Version verifies the engine can be used.
+	Version(ctx context.Context) error
+
+	// Clone checks out the target branch, creating an orphan branch when
+	// the branch is not present on the remote.
+	Clone(ctx context.Context) error
+
+	// Author configures the commit author.
+	Author(ctx context.Context) error
+
+	// Stage stages all changes in the checkout.
+	Stage(ctx context.Context) error
+
+	// Commit commits the staged changes.
+	Commit(ctx context.Context) error
+
+	// Rewrite replaces the history of the branch with the last keep
+	// commits on a new root.
+	Rewrite(ctx context.Context, keep int) error
+
+	// Verify checks the signature of the commit at the tip of the branch.
+	Verify(ctx context.Context) error
+
+	// Push pushes the target branch to the remote.
+	Push(ctx context.Context) error
+
+	// Mirror force pushes the branch to a mirror so it matches the remote.
+	Mirror(ctx context.Context, m *mirror) error
+
+	// Head returns the commit at the tip of the branch.
+	Head(ctx context.Context) (string, error)
+
+	// Dirty reports whether the checkout has changes.
+	Dirty(ctx context.Context) bool
+
+	// Changes returns the staged changes with the file sizes before and
+	// after the change.
+	Changes(ctx context.Context) ([]fileChange, error)
+
+	// LastUpdated returns the time of the last commit touching the path.
+	// Paths without history are treated as updated now.
+	LastUpdated(ctx context.Context, path string) (time.Time, error)
+}
+
+func newGitEngine(args *Args) (gitEngine, error) {
+	switch args.GitEngine {
+	case engineCLI:
+		return &cliGit{args: args}, nil
+	case engineGo:
+		return &goGit{args: args}, nil
+	}
+
+	return nil, fmt.Errorf("unknown git engine %s: %w", args.GitEngine, errConfiguration)
+}
+
+// rejectedPush reports whether push output shows the remote moved on.
+func rejectedPush(output string) bool {
+	for _, marker := range []string{"non-fast-forward", "fetch first", "[rejected]"} {
+		if strings.Contains(output, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func seedFiles(args *Args) error {
+	for _, seed := range args.PagesRepo.Seed {
+		dst := filepath.Join(args.PagesRepo.Checkout, filepath.Base(seed))
+
+		if err := copyFile(seed, dst); err != nil {
+			return fmt.Errorf("could not seed %s: %w", seed, err)
+		}
+
+		logrus.Infof("seeded %s into orphan branch\n", filepath.Base(seed))
+	}
+
+	return nil
+}
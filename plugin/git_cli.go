@@ -0,0 +1,701 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appleboy/drone-git-push/repo"
+	"github.com/sirupsen/logrus"
+)
+
+// cliGit runs git operations through the git binary.
+type cliGit struct {
+	args *Args
+
+	// base is the tip of the branch when cloned
+	base string
+}
+
+func (g *cliGit) Version(ctx context.Context) error {
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		"version",
+	)
+	cmd.Dir = g.args.PagesRepo.Checkout
+
+	return runCommand(cmd)
+}
+
+func (g *cliGit) Clone(ctx context.Context) error {
+	exists, err := g.remoteBranchExists(ctx)
+	if err != nil {
+		return fmt.Errorf("could not query remote branches: %w", err)
+	}
+
+	if !exists {
+		logrus.Infof("branch %s not found on remote, creating orphan branch\n", g.args.PagesRepo.Branch)
+
+		g.base = ""
+
+		return g.initTarget(ctx)
+	}
+
+	clone := []string{
+		"clone",
+	}
+
+	if g.args.SkipVerify {
+		clone = append(
+			clone,
+			"--config",
+			"http.sslVerify=false",
+		)
+
+		logrus.Warningf("ssl verification is turned off")
+	}
+
+	if g.args.Clone.Depth > 0 {
+		clone = append(
+			clone,
+			"--depth",
+			strconv.Itoa(g.args.Clone.Depth),
+		)
+	}
+
+	if g.args.Clone.Filter != "" {
+		clone = append(
+			clone,
+			"--filter",
+			g.args.Clone.Filter,
+		)
+	}
+
+	if len(g.args.Clone.Paths) > 0 {
+		clone = append(
+			clone,
+			"--no-checkout",
+		)
+	}
+
+	clone = append(
+		clone,
+		"--branch",
+		g.args.PagesRepo.Branch,
+		"--origin",
+		g.args.PagesRepo.Name,
+		"--single-branch",
+		g.args.PagesRepo.Remote,
+		g.args.PagesRepo.Checkout,
+	)
+
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		clone...,
+	)
+
+	if err := runCommand(cmd); err != nil {
+		return err
+	}
+
+	if len(g.args.Clone.Paths) > 0 {
+		if err := g.sparseCheckout(ctx); err != nil {
+			return fmt.Errorf("sparse checkout failed: %w", err)
+		}
+	}
+
+	g.base, err = g.output(ctx, "rev-parse", "HEAD")
+
+	return err
+}
+
+func (g *cliGit) sparseCheckout(ctx context.Context) error {
+	sparse := []string{
+		"sparse-checkout",
+		"set",
+		"--cone",
+		"--",
+	}
+
+	if _, err := g.output(ctx, append(sparse, g.args.Clone.Paths...)...); err != nil {
+		return err
+	}
+
+	_, err := g.output(ctx, "checkout", g.args.PagesRepo.Branch)
+
+	return err
+}
+
+func (g *cliGit) remoteBranchExists(ctx context.Context) (bool, error) {
+	lsRemote := []string{}
+
+	if g.args.SkipVerify {
+		lsRemote = append(
+			lsRemote,
+			"-c",
+			"http.sslVerify=false",
+		)
+	}
+
+	lsRemote = append(
+		lsRemote,
+		"ls-remote",
+		"--exit-code",
+		"--heads",
+		g.args.PagesRepo.Remote,
+		g.args.PagesRepo.Branch,
+	)
+
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		lsRemote...,
+	)
+	cmd.Stdout = io.Discard
+
+	err := runCommand(cmd)
+	if err == nil {
+		return true, nil
+	}
+
+	// ls-remote exits with 2 when no matching refs are found
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+		return false, nil
+	}
+
+	return false, err
+}
+
+func (g *cliGit) initTarget(ctx context.Context) error {
+	cmds := []*exec.Cmd{
+		exec.CommandContext(
+			ctx,
+			"git",
+			"init",
+			g.args.PagesRepo.Checkout,
+		),
+		exec.CommandContext(
+			ctx,
+			"git",
+			"symbolic-ref",
+			"HEAD",
+			"refs/heads/"+g.args.PagesRepo.Branch,
+		),
+		exec.CommandContext(
+			ctx,
+			"git",
+			"remote",
+			"add",
+			g.args.PagesRepo.Name,
+			g.args.PagesRepo.Remote,
+		),
+	}
+
+	if g.args.SkipVerify {
+		cmds = append(
+			cmds,
+			exec.CommandContext(
+				ctx,
+				"git",
+				"config",
+				"http.sslVerify",
+				"false",
+			),
+		)
+
+		logrus.Warningf("ssl verification is turned off")
+	}
+
+	for _, cmd := range cmds {
+		cmd.Dir = g.args.PagesRepo.Checkout
+
+		if err := runCommand(cmd); err != nil {
+			return err
+		}
+	}
+
+	return seedFiles(g.args)
+}
+
+func (g *cliGit) Author(ctx context.Context) error {
+	cmds := []*exec.Cmd{
+		exec.CommandContext(
+			ctx,
+			"git",
+			"config",
+			"user.name",
+			g.args.PagesCommit.Author.Name,
+		),
+		exec.CommandContext(
+			ctx,
+			"git",
+			"config",
+			"user.email",
+			g.args.PagesCommit.Author.Email,
+		),
+	}
+
+	if g.args.Signing.Key != "" {
+		settings := [][]string{
+			{"commit.gpgsign", "true"},
+			{"user.signingkey", g.args.Signing.KeyID},
+			{"gpg.format", g.args.Signing.Format},
+		}
+
+		if g.args.Signing.Format == signingSSH {
+			settings = append(settings, []string{"gpg.ssh.allowedSignersFile", g.args.Signing.AllowedSigners})
+		}
+
+		for _, setting := range settings {
+			cmds = append(cmds, exec.CommandContext(
+				ctx,
+				"git",
+				"config",
+				setting[0],
+				setting[1],
+			))
+		}
+	}
+
+	for _, cmd := range cmds {
+		cmd.Dir = g.args.PagesRepo.Checkout
+
+		if err := runCommand(cmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *cliGit) Stage(ctx context.Context) error {
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		"add",
+		".",
+	)
+	cmd.Dir = g.args.PagesRepo.Checkout
+
+	return runCommand(cmd)
+}
+
+func (g *cliGit) Commit(ctx context.Context) error {
+	commit := []string{
+		"commit",
+		"-m",
+		g.args.PagesCommit.Message,
+	}
+
+	if g.args.History.Strategy == historyAmend && g.base != "" {
+		commit = append(
+			commit,
+			"--amend",
+			"--reset-author",
+		)
+	}
+
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		commit...,
+	)
+	cmd.Dir = g.args.PagesRepo.Checkout
+
+	return runCommand(cmd)
+}
+
+func (g *cliGit) Push(ctx context.Context) error {
+	push := repo.RemotePush(
+		g.args.PagesRepo.Name,
+		g.args.PagesRepo.Branch,
+		g.args.PagesCommit.ForcePush,
+		false,
+	)
+	cmd := exec.CommandContext(ctx, push.Args[0], push.Args[1:]...)
+
+	// Rewritten history only replaces the tip that was cloned
+	if g.args.History.Strategy != historyAppend {
+		cmd.Args = append(
+			cmd.Args,
+			fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", g.args.PagesRepo.Branch, g.base),
+		)
+	}
+
+	res := bytes.NewBufferString("")
+	stderr := newRedactWriter(os.Stderr)
+
+	defer stderr.Flush()
+
+	cmd.Dir = g.args.PagesRepo.Checkout
+	cmd.Stderr = io.MultiWriter(stderr, res)
+
+	err := runCommand(cmd)
+	if err != nil && rejectedPush(res.String()) {
+		return fmt.Errorf("%w: %s", errPushRejected, err)
+	}
+
+	return err
+}
+
+func (g *cliGit) Rewrite(ctx context.Context, keep int) error {
+	revs, err := g.output(ctx, "rev-list", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	list := strings.Fields(revs)
+	if len(list) <= keep {
+		return nil
+	}
+
+	parent := ""
+
+	// Recreate the kept commits, oldest first, on a new root
+	for i := keep - 1; i >= 0; i-- {
+		info, err := g.output(ctx, "log", "-1", "--date=raw", "--format=%an%x00%ae%x00%ad%x00%B", list[i])
+		if err != nil {
+			return err
+		}
+
+		// Fields are author name, author email, author date and message
+		fields := strings.SplitN(info, "\x00", 4) //nolint:gomnd
+		if len(fields) != 4 {
+			return fmt.Errorf("could not read commit %s", list[i])
+		}
+
+		commitTree := []string{
+			"commit-tree",
+			list[i] + "^{tree}",
+		}
+
+		if g.args.Signing.Key != "" {
+			commitTree = append(commitTree, "-S")
+		}
+
+		if parent != "" {
+			commitTree = append(
+				commitTree,
+				"-p",
+				parent,
+			)
+		}
+
+		cmd := exec.CommandContext(
+			ctx,
+			"git",
+			commitTree...,
+		)
+
+		res := bytes.NewBufferString("")
+		cmd.Dir = g.args.PagesRepo.Checkout
+		cmd.Stdout = res
+		cmd.Stdin = strings.NewReader(fields[3])
+		cmd.Env = append(
+			os.Environ(),
+			"GIT_AUTHOR_NAME="+fields[0],
+			"GIT_AUTHOR_EMAIL="+fields[1],
+			"GIT_AUTHOR_DATE="+fields[2],
+		)
+
+		if err := runCommand(cmd); err != nil {
+			return err
+		}
+
+		parent = strings.TrimSpace(res.String())
+	}
+
+	if _, err := g.output(ctx, "update-ref", "refs/heads/"+g.args.PagesRepo.Branch, parent); err != nil {
+		return err
+	}
+
+	logrus.Infof("rewrote history keeping the last %d commits\n", keep)
+
+	return nil
+}
+
+func (g *cliGit) Mirror(ctx context.Context, m *mirror) error {
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		"push",
+		"--force",
+		m.URL,
+		"HEAD:refs/heads/"+m.Branch,
+	)
+	cmd.Dir = g.args.PagesRepo.Checkout
+	cmd.Env = os.Environ()
+
+	// Credentials go through the environment to stay out of the trace
+	if m.Password != "" {
+		cmd.Env = append(
+			cmd.Env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0="+m.basicAuthHeader(),
+		)
+	}
+
+	if m.KeyFile != "" {
+		cmd.Env = append(
+			cmd.Env,
+			fmt.Sprintf(
+				"GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o StrictHostKeyChecking=%s -o UserKnownHostsFile=%s",
+				m.KeyFile,
+				hostKeyChecking(g.args),
+				g.args.SSH.KnownHostsFile,
+			),
+		)
+	}
+
+	return runCommand(cmd)
+}
+
+func (g *cliGit) Verify(ctx context.Context) error {
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		"verify-commit",
+		"HEAD",
+	)
+	cmd.Dir = g.args.PagesRepo.Checkout
+
+	return runCommand(cmd)
+}
+
+func (g *cliGit) Head(ctx context.Context) (string, error) {
+	return g.output(ctx, "rev-parse", "HEAD")
+}
+
+func (g *cliGit) Dirty(ctx context.Context) bool {
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		"status",
+		"--porcelain",
+	)
+
+	res := bytes.NewBufferString("")
+	cmd.Dir = g.args.PagesRepo.Checkout
+	cmd.Stdout = res
+	cmd.Stderr = res
+
+	err := runCommand(cmd)
+	if err != nil {
+		return false
+	}
+
+	if res.Len() > 0 {
+		fmt.Fprintf(os.Stdout, "%s\n", res.String())
+
+		return true
+	}
+
+	return false
+}
+
+func (g *cliGit) LastUpdated(ctx context.Context, path string) (time.Time, error) {
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		"log",
+		"-1",
+		"--format=%ct",
+		"--",
+		path,
+	)
+
+	res := bytes.NewBufferString("")
+	cmd.Dir = g.args.PagesRepo.Checkout
+	cmd.Stdout = res
+
+	// An unborn branch has no log
+	if err := runCommand(cmd); err != nil {
+		return time.Now(), nil //nolint:nilerr
+	}
+
+	out := strings.TrimSpace(res.String())
+	if out == "" {
+		return time.Now(), nil
+	}
+
+	seconds, err := strconv.ParseInt(out, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(seconds, 0), nil
+}
+
+func (g *cliGit) Changes(ctx context.Context) ([]fileChange, error) {
+	diff := exec.CommandContext(
+		ctx,
+		"git",
+		"diff",
+		"--cached",
+		"--name-status",
+		"--no-renames",
+		"-z",
+	)
+
+	res := bytes.NewBufferString("")
+	diff.Dir = g.args.PagesRepo.Checkout
+	diff.Stdout = res
+
+	if err := runCommand(diff); err != nil {
+		// An unborn branch has nothing to diff against
+		return g.unbornChanges(ctx)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(res.String(), "\x00"), "\x00")
+	existing := []string{}
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] != "A" {
+			existing = append(existing, fields[i+1])
+		}
+	}
+
+	sizes, err := g.headSizes(ctx, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []fileChange{}
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		change := fileChange{
+			Path:    fields[i+1],
+			OldSize: sizes[fields[i+1]],
+		}
+
+		switch fields[i] {
+		case "A":
+			change.Status = changeAdded
+		case "D":
+			change.Status = changeDeleted
+		default:
+			change.Status = changeModified
+		}
+
+		if change.Status != changeDeleted {
+			change.NewSize = fileSize(filepath.Join(g.args.PagesRepo.Checkout, change.Path))
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+func (g *cliGit) unbornChanges(ctx context.Context) ([]fileChange, error) {
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		"ls-files",
+		"-z",
+	)
+
+	res := bytes.NewBufferString("")
+	cmd.Dir = g.args.PagesRepo.Checkout
+	cmd.Stdout = res
+
+	if err := runCommand(cmd); err != nil {
+		return nil, err
+	}
+
+	changes := []fileChange{}
+
+	for _, path := range strings.Split(res.String(), "\x00") {
+		if path == "" {
+			continue
+		}
+
+		changes = append(changes, fileChange{
+			Path:    path,
+			Status:  changeAdded,
+			NewSize: fileSize(filepath.Join(g.args.PagesRepo.Checkout, path)),
+		})
+	}
+
+	return changes, nil
+}
+
+// headSizes returns the size of the paths at HEAD. Only the requested
+// objects are read so partial clones do not fetch every blob.
+func (g *cliGit) headSizes(ctx context.Context, paths []string) (map[string]int64, error) {
+	sizes := map[string]int64{}
+
+	if len(paths) == 0 {
+		return sizes, nil
+	}
+
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		"cat-file",
+		"--batch-check=%(objectsize)",
+	)
+
+	var input strings.Builder
+	for _, path := range paths {
+		input.WriteString("HEAD:" + path + "\n")
+	}
+
+	res := bytes.NewBufferString("")
+	cmd.Dir = g.args.PagesRepo.Checkout
+	cmd.Stdin = strings.NewReader(input.String())
+	cmd.Stdout = res
+
+	if err := runCommand(cmd); err != nil {
+		return nil, err
+	}
+
+	// One line per path, either the size or a missing notice
+	for i, line := range strings.Split(strings.TrimSpace(res.String()), "\n") {
+		if i >= len(paths) {
+			break
+		}
+
+		if size, err := strconv.ParseInt(line, 10, 64); err == nil {
+			sizes[paths[i]] = size
+		}
+	}
+
+	return sizes, nil
+}
+
+// output runs git in the checkout and returns the trimmed stdout.
+func (g *cliGit) output(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(
+		ctx,
+		"git",
+		args...,
+	)
+
+	res := bytes.NewBufferString("")
+	cmd.Dir = g.args.PagesRepo.Checkout
+	cmd.Stdout = res
+
+	if err := runCommand(cmd); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(res.String()), nil
+}
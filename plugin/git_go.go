@@ -0,0 +1,576 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/sirupsen/logrus"
+)
+
+// goGit runs git operations in process without requiring the git binary.
+type goGit struct {
+	args *Args
+	repo *git.Repository
+
+	// signer signs commits when a signing key is configured
+	signer commitSigner
+
+	// base is the tip of the branch when cloned
+	base plumbing.Hash
+}
+
+func (g *goGit) Version(ctx context.Context) error {
+	return nil
+}
+
+func (g *goGit) Clone(ctx context.Context) error {
+	auth, err := g.auth()
+	if err != nil {
+		return err
+	}
+
+	if g.args.SkipVerify {
+		logrus.Warningf("ssl verification is turned off")
+	}
+
+	exists, err := g.remoteBranchExists(ctx, auth)
+	if err != nil {
+		return fmt.Errorf("could not query remote branches: %w", err)
+	}
+
+	if !exists {
+		logrus.Infof("branch %s not found on remote, creating orphan branch\n", g.args.PagesRepo.Branch)
+
+		g.base = plumbing.ZeroHash
+
+		return g.initTarget()
+	}
+
+	logrus.Infof("+ clone %s %s (branch %s)\n", redactURL(g.args.PagesRepo.Remote), g.args.PagesRepo.Checkout, g.args.PagesRepo.Branch)
+
+	progress := newRedactWriter(os.Stdout)
+	defer progress.Flush()
+
+	g.repo, err = git.PlainCloneContext(ctx, g.args.PagesRepo.Checkout, false, &git.CloneOptions{
+		URL:             g.args.PagesRepo.Remote,
+		Auth:            auth,
+		RemoteName:      g.args.PagesRepo.Name,
+		ReferenceName:   plumbing.NewBranchReferenceName(g.args.PagesRepo.Branch),
+		SingleBranch:    true,
+		Depth:           g.args.Clone.Depth,
+		InsecureSkipTLS: g.args.SkipVerify,
+		Progress:        progress,
+	})
+	if err != nil {
+		return err
+	}
+
+	head, err := g.repo.Head()
+	if err != nil {
+		return err
+	}
+
+	g.base = head.Hash()
+
+	return nil
+}
+
+func (g *goGit) remoteBranchExists(ctx context.Context, auth transport.AuthMethod) (bool, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: g.args.PagesRepo.Name,
+		URLs: []string{g.args.PagesRepo.Remote},
+	})
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth:            auth,
+		InsecureSkipTLS: g.args.SkipVerify,
+	})
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	branch := plumbing.NewBranchReferenceName(g.args.PagesRepo.Branch)
+
+	for _, ref := range refs {
+		if ref.Name() == branch {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (g *goGit) initTarget() error {
+	var err error
+
+	g.repo, err = git.PlainInit(g.args.PagesRepo.Checkout, false)
+	if err != nil {
+		return err
+	}
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(g.args.PagesRepo.Branch))
+	if err := g.repo.Storer.SetReference(head); err != nil {
+		return fmt.Errorf("could not set HEAD: %w", err)
+	}
+
+	_, err = g.repo.CreateRemote(&config.RemoteConfig{
+		Name: g.args.PagesRepo.Name,
+		URLs: []string{g.args.PagesRepo.Remote},
+	})
+	if err != nil {
+		return fmt.Errorf("could not add remote: %w", err)
+	}
+
+	return seedFiles(g.args)
+}
+
+func (g *goGit) Author(ctx context.Context) error {
+	cfg, err := g.repo.Config()
+	if err != nil {
+		return err
+	}
+
+	cfg.User.Name = g.args.PagesCommit.Author.Name
+	cfg.User.Email = g.args.PagesCommit.Author.Email
+
+	if g.args.Signing.Key != "" {
+		if g.signer, err = newCommitSigner(g.args); err != nil {
+			return err
+		}
+	}
+
+	return g.repo.SetConfig(cfg)
+}
+
+func (g *goGit) Stage(ctx context.Context) error {
+	wt, err := g.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	return wt.AddWithOptions(&git.AddOptions{
+		All: true,
+	})
+}
+
+func (g *goGit) Commit(ctx context.Context) error {
+	wt, err := g.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	hash, err := wt.Commit(g.args.PagesCommit.Message, &git.CommitOptions{
+		Author: g.signature(),
+		Amend:  g.args.History.Strategy == historyAmend && !g.base.IsZero(),
+		Signer: g.signer,
+	})
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("created commit %s\n", hash)
+
+	return nil
+}
+
+func (g *goGit) Push(ctx context.Context) error {
+	auth, err := g.auth()
+	if err != nil {
+		return err
+	}
+
+	branch := plumbing.NewBranchReferenceName(g.args.PagesRepo.Branch)
+	refSpec := config.RefSpec(fmt.Sprintf("%s:%s", branch, branch))
+
+	var lease *git.ForceWithLease
+
+	// Rewritten history only replaces the tip that was cloned
+	if g.args.History.Strategy != historyAppend && !g.base.IsZero() {
+		lease = &git.ForceWithLease{
+			RefName: branch,
+			Hash:    g.base,
+		}
+	}
+
+	if g.args.PagesCommit.ForcePush || lease != nil {
+		refSpec = "+" + refSpec
+	}
+
+	logrus.Infof("+ push %s %s\n", g.args.PagesRepo.Name, refSpec)
+
+	progress := newRedactWriter(os.Stdout)
+	defer progress.Flush()
+
+	err = g.repo.PushContext(ctx, &git.PushOptions{
+		RemoteName:      g.args.PagesRepo.Name,
+		RefSpecs:        []config.RefSpec{refSpec},
+		Auth:            auth,
+		ForceWithLease:  lease,
+		InsecureSkipTLS: g.args.SkipVerify,
+		Progress:        progress,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+
+	if errors.Is(err, git.ErrForceNeeded) || (err != nil && rejectedPush(err.Error())) {
+		return fmt.Errorf("%w: %s", errPushRejected, err)
+	}
+
+	return err
+}
+
+func (g *goGit) Rewrite(ctx context.Context, keep int) error {
+	head, err := g.repo.Head()
+	if err != nil {
+		return err
+	}
+
+	commits, err := g.repo.Log(&git.LogOptions{
+		From: head.Hash(),
+	})
+	if err != nil {
+		return err
+	}
+
+	kept := []*object.Commit{}
+
+	err = commits.ForEach(func(commit *object.Commit) error {
+		kept = append(kept, commit)
+
+		if len(kept) > keep {
+			return storer.ErrStop
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(kept) <= keep {
+		return nil
+	}
+
+	parent := plumbing.ZeroHash
+
+	// Recreate the kept commits, oldest first, on a new root
+	for i := keep - 1; i >= 0; i-- {
+		rewritten := &object.Commit{
+			Author:    kept[i].Author,
+			Committer: kept[i].Committer,
+			Message:   kept[i].Message,
+			TreeHash:  kept[i].TreeHash,
+		}
+
+		if !parent.IsZero() {
+			rewritten.ParentHashes = []plumbing.Hash{parent}
+		}
+
+		if g.signer != nil {
+			if err := g.sign(rewritten); err != nil {
+				return err
+			}
+		}
+
+		obj := g.repo.Storer.NewEncodedObject()
+		if err := rewritten.Encode(obj); err != nil {
+			return err
+		}
+
+		if parent, err = g.repo.Storer.SetEncodedObject(obj); err != nil {
+			return err
+		}
+	}
+
+	ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(g.args.PagesRepo.Branch), parent)
+	if err := g.repo.Storer.SetReference(ref); err != nil {
+		return err
+	}
+
+	logrus.Infof("rewrote history keeping the last %d commits\n", keep)
+
+	return nil
+}
+
+func (g *goGit) Mirror(ctx context.Context, m *mirror) error {
+	var auth transport.AuthMethod
+
+	switch {
+	case m.Key != "":
+		keys, err := gitssh.NewPublicKeys("git", []byte(m.Key), "")
+		if err != nil {
+			return fmt.Errorf("could not parse ssh key: %w", err)
+		}
+
+		if keys.HostKeyCallback, err = hostKeyCallback(g.args); err != nil {
+			return err
+		}
+
+		auth = keys
+	case m.Password != "":
+		auth = &githttp.BasicAuth{
+			Username: m.Username,
+			Password: m.Password,
+		}
+	}
+
+	branch := plumbing.NewBranchReferenceName(g.args.PagesRepo.Branch)
+	refSpec := config.RefSpec(fmt.Sprintf("+%s:%s", branch, plumbing.NewBranchReferenceName(m.Branch)))
+
+	logrus.Infof("+ push %s %s\n", redactURL(m.URL), refSpec)
+
+	progress := newRedactWriter(os.Stdout)
+	defer progress.Flush()
+
+	err := g.repo.PushContext(ctx, &git.PushOptions{
+		RemoteName:      g.args.PagesRepo.Name,
+		RemoteURL:       m.URL,
+		RefSpecs:        []config.RefSpec{refSpec},
+		Auth:            auth,
+		InsecureSkipTLS: g.args.SkipVerify,
+		Progress:        progress,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+
+	return err
+}
+
+func (g *goGit) Verify(ctx context.Context) error {
+	head, err := g.repo.Head()
+	if err != nil {
+		return err
+	}
+
+	commit, err := g.repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	if commit.PGPSignature == "" || g.signer == nil {
+		return fmt.Errorf("commit %s is not signed: %w", commit.Hash, errSignature)
+	}
+
+	data, err := unsignedCommit(commit)
+	if err != nil {
+		return err
+	}
+
+	if err := g.signer.Verify(commit.PGPSignature, data); err != nil {
+		return fmt.Errorf("commit %s: %w", commit.Hash, err)
+	}
+
+	logrus.Infof("verified signature of commit %s\n", commit.Hash)
+
+	return nil
+}
+
+// sign adds a signature to a commit that is not yet stored.
+func (g *goGit) sign(commit *object.Commit) error {
+	data, err := unsignedCommit(commit)
+	if err != nil {
+		return err
+	}
+
+	signature, err := g.signer.Sign(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	commit.PGPSignature = string(signature)
+
+	return nil
+}
+
+// unsignedCommit returns the encoded commit without its signature.
+func unsignedCommit(commit *object.Commit) ([]byte, error) {
+	obj := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(obj); err != nil {
+		return nil, err
+	}
+
+	reader, err := obj.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func (g *goGit) Head(ctx context.Context) (string, error) {
+	head, err := g.repo.Head()
+	if err != nil {
+		return "", err
+	}
+
+	return head.Hash().String(), nil
+}
+
+func (g *goGit) Dirty(ctx context.Context) bool {
+	wt, err := g.repo.Worktree()
+	if err != nil {
+		return false
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return false
+	}
+
+	if !status.IsClean() {
+		fmt.Fprintf(os.Stdout, "%s\n", status.String())
+
+		return true
+	}
+
+	return false
+}
+
+func (g *goGit) Changes(ctx context.Context) ([]fileChange, error) {
+	wt, err := g.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var tree *object.Tree
+
+	if head, err := g.repo.Head(); err == nil {
+		commit, err := g.repo.CommitObject(head.Hash())
+		if err != nil {
+			return nil, err
+		}
+
+		if tree, err = commit.Tree(); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := []fileChange{}
+
+	for path, file := range status {
+		change := fileChange{
+			Path: path,
+		}
+
+		switch file.Staging {
+		case git.Added:
+			change.Status = changeAdded
+		case git.Deleted:
+			change.Status = changeDeleted
+		case git.Modified, git.Renamed, git.Copied:
+			change.Status = changeModified
+		default:
+			continue
+		}
+
+		if tree != nil {
+			if old, err := tree.File(path); err == nil {
+				change.OldSize = old.Size
+			}
+		}
+
+		if change.Status != changeDeleted {
+			change.NewSize = fileSize(filepath.Join(g.args.PagesRepo.Checkout, path))
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+func (g *goGit) LastUpdated(ctx context.Context, path string) (time.Time, error) {
+	commits, err := g.repo.Log(&git.LogOptions{
+		PathFilter: func(file string) bool {
+			return file == path || strings.HasPrefix(file, path+"/")
+		},
+	})
+
+	// An unborn branch has no log
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return time.Now(), nil
+	}
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	defer commits.Close()
+
+	commit, err := commits.Next()
+	if errors.Is(err, io.EOF) {
+		return time.Now(), nil
+	}
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return commit.Committer.When, nil
+}
+
+func (g *goGit) signature() *object.Signature {
+	return &object.Signature{
+		Name:  g.args.PagesCommit.Author.Name,
+		Email: g.args.PagesCommit.Author.Email,
+		When:  time.Now(),
+	}
+}
+
+func (g *goGit) auth() (transport.AuthMethod, error) {
+	if g.args.Key != "" {
+		keys, err := gitssh.NewPublicKeys("git", []byte(g.args.Key), "")
+		if err != nil {
+			return nil, fmt.Errorf("could not parse ssh key: %w", err)
+		}
+
+		if keys.HostKeyCallback, err = hostKeyCallback(g.args); err != nil {
+			return nil, err
+		}
+
+		return keys, nil
+	}
+
+	if g.args.Netrc.Password != "" {
+		return &githttp.BasicAuth{
+			Username: g.args.Netrc.Login,
+			Password: g.args.Netrc.Password,
+		}, nil
+	}
+
+	return nil, nil //nolint:nilnil
+}
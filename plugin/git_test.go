@@ -0,0 +1,574 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// engines are the git engines the publish tests run against.
+var engines = []string{engineCLI, engineGo}
+
+func init() {
+	// Serve local remotes in process so the go engine does not need git
+	client.InstallProtocol("file", server.NewClient(server.DefaultLoader))
+}
+
+func TestPublish(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing map[string]string
+		branch   string
+		seed     bool
+		history  string
+		keep     int
+		publish  []map[string]string
+		want     map[string]string
+		messages []string
+	}{
+		{
+			name:     "orphan branch is created in an empty remote",
+			publish:  []map[string]string{{"index.html": "one"}},
+			want:     map[string]string{"index.html": "one"},
+			messages: []string{"publish 1"},
+		},
+		{
+			name:     "orphan branch holds the seed files",
+			seed:     true,
+			publish:  []map[string]string{{"index.html": "one"}},
+			want:     map[string]string{"index.html": "one", ".nojekyll": ""},
+			messages: []string{"publish 1"},
+		},
+		{
+			name:     "orphan branch does not share history with other branches",
+			existing: map[string]string{"main.go": "package main"},
+			branch:   "main",
+			publish:  []map[string]string{{"index.html": "one"}},
+			want:     map[string]string{"index.html": "one"},
+			messages: []string{"publish 1"},
+		},
+		{
+			name:     "existing branch is updated",
+			publish:  []map[string]string{{"index.html": "one"}, {"index.html": "two", "guide.html": "guide"}},
+			want:     map[string]string{"index.html": "two", "guide.html": "guide"},
+			messages: []string{"publish 2", "publish 1"},
+		},
+		{
+			name:     "unchanged pages are not committed",
+			publish:  []map[string]string{{"index.html": "one"}, {"index.html": "one"}},
+			want:     map[string]string{"index.html": "one"},
+			messages: []string{"publish 1"},
+		},
+		{
+			name:     "amend replaces the tip",
+			history:  historyAmend,
+			keep:     1,
+			publish:  []map[string]string{{"index.html": "one"}, {"index.html": "two"}, {"index.html": "three"}},
+			want:     map[string]string{"index.html": "three"},
+			messages: []string{"publish 3"},
+		},
+		{
+			name:     "single keeps one commit",
+			history:  historySingle,
+			keep:     1,
+			publish:  []map[string]string{{"index.html": "one"}, {"index.html": "two"}, {"index.html": "three"}},
+			want:     map[string]string{"index.html": "three"},
+			messages: []string{"publish 3"},
+		},
+		{
+			name:     "keep last drops older commits",
+			history:  historyKeepLast + "2",
+			keep:     2,
+			publish:  []map[string]string{{"index.html": "one"}, {"index.html": "two"}, {"index.html": "three"}},
+			want:     map[string]string{"index.html": "three"},
+			messages: []string{"publish 3", "publish 2"},
+		},
+		{
+			name:     "keep last drops the commits of other publishers",
+			existing: map[string]string{"index.html": "other"},
+			branch:   "gh-pages",
+			history:  historyKeepLast + "2",
+			keep:     2,
+			publish:  []map[string]string{{"index.html": "one"}, {"index.html": "two"}},
+			want:     map[string]string{"index.html": "two"},
+			messages: []string{"publish 2", "publish 1"},
+		},
+	}
+
+	for _, engine := range engines {
+		for _, test := range tests {
+			t.Run(engine+"/"+test.name, func(t *testing.T) {
+				remote := newRemote(t)
+
+				if test.existing != nil {
+					pushBranch(t, remote, test.branch, test.existing)
+				}
+
+				for i, files := range test.publish {
+					args := publishTestArgs(t, engine, remote, files)
+					args.PagesCommit.Message = "publish " + strconv.Itoa(i+1)
+
+					if test.history != "" {
+						args.History.Strategy = test.history
+						args.History.Keep = test.keep
+					}
+
+					if test.seed {
+						seeds := t.TempDir()
+						writeFiles(t, seeds, map[string]string{".nojekyll": ""})
+						args.PagesRepo.Seed = []string{filepath.Join(seeds, ".nojekyll")}
+					}
+
+					if _, err := process(context.Background(), args, testEngine(t, args)); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				files, messages := branchContents(t, remote, "gh-pages")
+
+				if !reflect.DeepEqual(files, test.want) {
+					t.Errorf("got files %v, want %v", files, test.want)
+				}
+
+				if !reflect.DeepEqual(messages, test.messages) {
+					t.Errorf("got history %q, want %q", messages, test.messages)
+				}
+
+				if test.existing != nil && test.branch != "gh-pages" {
+					if other, _ := branchContents(t, remote, test.branch); !reflect.DeepEqual(other, test.existing) {
+						t.Errorf("branch %s changed to %v", test.branch, other)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestPublishUnreachableRemote checks a remote that cannot be listed fails
+// the publish instead of starting an orphan branch.
+func TestPublishUnreachableRemote(t *testing.T) {
+	for _, engine := range engines {
+		t.Run(engine, func(t *testing.T) {
+			args := publishTestArgs(t, engine, filepath.Join(t.TempDir(), "missing.git"), map[string]string{"index.html": "one"})
+
+			if _, err := process(context.Background(), args, testEngine(t, args)); err == nil {
+				t.Error("publish to a missing remote succeeded")
+			}
+		})
+	}
+}
+
+// TestPublishCloneOptions publishes from shallow, partial and sparse
+// clones of a branch with some history.
+func TestPublishCloneOptions(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	tests := []struct {
+		name    string
+		engine  string
+		depth   int
+		filter  string
+		sparse  bool
+		commits int
+		absent  string
+	}{
+		{name: "full clone", engine: engineCLI, commits: 4},
+		{name: "depth", engine: engineCLI, depth: 1, commits: 2},
+		{name: "depth", engine: engineGo, depth: 1, commits: 2},
+		{name: "filter", engine: engineCLI, filter: "blob:none", commits: 4},
+		{name: "sparse", engine: engineCLI, sparse: true, commits: 4, absent: "api/index.html"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.engine+"/"+test.name, func(t *testing.T) {
+			remote := newRemote(t)
+
+			for _, version := range []string{"1", "2", "3"} {
+				pushBranch(t, remote, "gh-pages", map[string]string{"docs/index.html": version, "api/index.html": version})
+			}
+
+			gitConfig(t, remote, "uploadpack.allowFilter", "true")
+
+			// The in-process transport does not serve shallow clones
+			if test.engine == engineGo && test.depth > 0 {
+				client.InstallProtocol("file", file.DefaultClient)
+				t.Cleanup(func() {
+					client.InstallProtocol("file", server.NewClient(server.DefaultLoader))
+				})
+			}
+
+			args := publishTestArgs(t, test.engine, "file://"+remote, map[string]string{"index.html": "new"})
+			args.TargetDirectory = "docs"
+			args.Rsync.Destination = filepath.Join(args.PagesRepo.Checkout, "docs")
+			args.Clone.Depth = test.depth
+			args.Clone.Filter = test.filter
+
+			if test.sparse {
+				args.Clone.Paths = sparsePaths(args)
+			}
+
+			if _, err := process(context.Background(), args, testEngine(t, args)); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.TrimSpace(gitOutput(t, args.PagesRepo.Checkout, "rev-list", "--count", "HEAD")); got != strconv.Itoa(test.commits) {
+				t.Errorf("got %s commits in the checkout, want %d", got, test.commits)
+			}
+
+			if test.filter != "" {
+				if got := strings.TrimSpace(gitOutput(t, args.PagesRepo.Checkout, "config", "remote.origin.partialclonefilter")); got != test.filter {
+					t.Errorf("got filter %q, want %q", got, test.filter)
+				}
+			}
+
+			if test.absent != "" {
+				if _, err := os.Stat(filepath.Join(args.PagesRepo.Checkout, test.absent)); !os.IsNotExist(err) {
+					t.Errorf("%s checked out by a sparse clone", test.absent)
+				}
+			}
+
+			files, messages := branchContents(t, remote, "gh-pages")
+
+			if want := map[string]string{"docs/index.html": "new", "api/index.html": "3"}; !reflect.DeepEqual(files, want) {
+				t.Errorf("got files %v, want %v", files, want)
+			}
+
+			if len(messages) != 4 {
+				t.Errorf("got history %q, want the publish on top of the 3 commits", messages)
+			}
+		})
+	}
+}
+
+// TestPublishLeaseRejected checks rewritten history is not pushed over
+// commits another publisher pushed after the clone.
+func TestPublishLeaseRejected(t *testing.T) {
+	tests := []struct {
+		history string
+		keep    int
+	}{
+		{history: historyAmend, keep: 1},
+		{history: historySingle, keep: 1},
+		{history: historyKeepLast + "2", keep: 2},
+	}
+
+	for _, engine := range engines {
+		for _, test := range tests {
+			t.Run(engine+"/"+test.history, func(t *testing.T) {
+				remote := newRemote(t)
+
+				for i, files := range []map[string]string{{"index.html": "one"}, {"index.html": "two"}} {
+					args := publishTestArgs(t, engine, remote, files)
+					args.PagesCommit.Message = "publish " + strconv.Itoa(i+1)
+					args.History.Strategy = test.history
+					args.History.Keep = test.keep
+
+					git := testEngine(t, args)
+
+					// The remote moves between the clone and the second push
+					if i == 1 {
+						git = &advancingEngine{gitEngine: git, t: t, remote: remote, branch: "gh-pages", advance: 1}
+					}
+
+					_, err := process(context.Background(), args, git)
+
+					if i == 0 && err != nil {
+						t.Fatal(err)
+					}
+
+					if i == 1 && !errors.Is(err, errPushRejected) {
+						t.Fatalf("got %v, want %v", err, errPushRejected)
+					}
+				}
+
+				files, messages := branchContents(t, remote, "gh-pages")
+
+				if want := map[string]string{"index.html": "one", "other.html": "1"}; !reflect.DeepEqual(files, want) {
+					t.Errorf("got files %v, want %v", files, want)
+				}
+
+				if want := []string{"pushed by another publisher", "publish 1"}; !reflect.DeepEqual(messages, want) {
+					t.Errorf("got history %q, want %q", messages, want)
+				}
+			})
+		}
+	}
+}
+
+// advancingEngine pushes a commit of another publisher to the branch of the
+// remote before the first pushes of the engine, as if racing the publish.
+type advancingEngine struct {
+	gitEngine
+
+	t       *testing.T
+	remote  string
+	branch  string
+	advance int
+	pushes  int
+}
+
+func (e *advancingEngine) Push(ctx context.Context) error {
+	e.pushes++
+
+	if e.pushes <= e.advance {
+		pushBranch(e.t, e.remote, e.branch, map[string]string{"other.html": strconv.Itoa(e.pushes)})
+	}
+
+	return e.gitEngine.Push(ctx)
+}
+
+// testEngine returns the engine of the args, skipping the test when the
+// engine needs a git binary that is not installed.
+func testEngine(t *testing.T, args *Args) gitEngine {
+	t.Helper()
+
+	if args.GitEngine == engineCLI {
+		if _, err := exec.LookPath("git"); err != nil {
+			t.Skip("git not installed")
+		}
+
+		// Keep the configuration of the host out of the checkout
+		t.Setenv("HOME", t.TempDir())
+		t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	}
+
+	git, err := newGitEngine(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return git
+}
+
+func publishTestArgs(t *testing.T, engine, remote string, files map[string]string) *Args {
+	t.Helper()
+
+	src := t.TempDir()
+	writeFiles(t, src, files)
+
+	args := &Args{}
+	args.GitEngine = engine
+	args.SyncEngine = syncGo
+	args.PagesRepo.Remote = remote
+	args.PagesRepo.Name = "origin"
+	args.PagesRepo.Branch = "gh-pages"
+	args.PagesRepo.Checkout = t.TempDir()
+	args.PagesCommit.Message = "publish"
+	args.PagesCommit.Author.Name = "Drone"
+	args.PagesCommit.Author.Email = "drone@example.com"
+	args.History.Strategy = historyAppend
+	args.TargetDirectory = "."
+	args.Rsync.Source = src + "/"
+	args.Rsync.Destination = args.PagesRepo.Checkout
+	args.Rsync.Delete = true
+	args.Retry.Backoff = time.Millisecond
+	args.Retry.MaxBackoff = time.Millisecond
+
+	return args
+}
+
+// newRemote creates an empty bare repository.
+func newRemote(t *testing.T) string {
+	t.Helper()
+
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	if _, err := git.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+
+	return remote
+}
+
+// pushBranch pushes a commit holding the files to the branch of the remote,
+// on top of the branch when it exists.
+func pushBranch(t *testing.T, remote, branch string, files map[string]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}}); err != nil {
+		t.Fatal(err)
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref := plumbing.NewBranchReferenceName(branch)
+
+	if _, err := remoteRef(remote, ref); err == nil {
+		if err := repo.Fetch(&git.FetchOptions{RefSpecs: []config.RefSpec{config.RefSpec("+" + ref + ":" + ref)}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := wt.Checkout(&git.CheckoutOptions{Branch: ref}); err != nil {
+			t.Fatal(err)
+		}
+	} else if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, ref)); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, dir, files)
+
+	if err := wt.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = wt.Commit("pushed by another publisher", &git.CommitOptions{
+		Author: &object.Signature{Name: "Other", Email: "other@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec(ref + ":" + ref)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func remoteRef(remote string, ref plumbing.ReferenceName) (plumbing.Hash, error) {
+	repo, err := git.PlainOpen(remote)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	tip, err := repo.Reference(ref, true)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return tip.Hash(), nil
+}
+
+// branchContents returns the files at the tip of the branch and the
+// messages of its history, newest first.
+func branchContents(t *testing.T, remote, branch string) (map[string]string, []string) {
+	t.Helper()
+
+	repo, err := git.PlainOpen(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tree.Files().ForEach(func(file *object.File) error {
+		content, err := file.Contents()
+		files[file.Name] = content
+
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []string{}
+
+	for _, commit := range branchCommits(t, remote, branch) {
+		messages = append(messages, strings.TrimSpace(commit.Message))
+	}
+
+	return files, messages
+}
+
+// branchCommits returns the history of the branch, newest first.
+func branchCommits(t *testing.T, remote, branch string) []*object.Commit {
+	t.Helper()
+
+	repo, err := git.PlainOpen(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := repo.Log(&git.LogOptions{From: ref.Hash()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commits := []*object.Commit{}
+
+	err = history.ForEach(func(commit *object.Commit) error {
+		commits = append(commits, commit)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return commits
+}
+
+// gitConfig sets a configuration value of the repository.
+func gitConfig(t *testing.T, dir, key, value string) {
+	t.Helper()
+
+	gitOutput(t, dir, "config", key, value)
+}
+
+// gitOutput runs the git binary in the directory.
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+	}
+
+	return string(out)
+}
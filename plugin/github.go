@@ -0,0 +1,28 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// githubHeaders returns the headers sent to the GitHub API.
+func githubHeaders(authorization string) map[string]string {
+	return map[string]string{
+		"Authorization":        authorization,
+		"Accept":               "application/vnd.github+json",
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
+}
+
+// githubRequest calls the GitHub API of the repository being built.
+func githubRequest(ctx context.Context, args *Args, method, path string, body, out interface{}) error {
+	endpoint := fmt.Sprintf("%s/repos/%s/%s%s", strings.TrimSuffix(args.GitHub.API, "/"), args.Repo.Namespace, args.Repo.Name, path)
+
+	return requestJSON(ctx, method, endpoint, githubHeaders("Bearer "+args.GitHub.Token), body, out)
+}
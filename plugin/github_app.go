@@ -0,0 +1,146 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// appTokenLogin is the netrc login used with installation tokens
+	appTokenLogin = "x-access-token"
+
+	// GitHub rejects tokens issued in the future so allow for clock drift
+	appJWTDrift    = 60 * time.Second
+	appJWTLifetime = 9 * time.Minute
+)
+
+// authenticateApp mints an installation token and uses it for git and the
+// GitHub API.
+func authenticateApp(ctx context.Context, args *Args) error {
+	fetchCtx, cancel := phaseContext(ctx, args.Timeout.Fetch)
+	token, err := installationToken(fetchCtx, args)
+	cancel()
+
+	if err != nil {
+		return fmt.Errorf("failed to create installation token: %w", err)
+	}
+
+	secrets.add(token)
+
+	// Installation tokens authenticate like a password
+	args.Key = ""
+	args.Netrc.Login = appTokenLogin
+	args.Netrc.Password = token
+
+	if args.GitHub.Token == "" {
+		args.GitHub.Token = token
+	}
+
+	return nil
+}
+
+// installationToken exchanges a JWT signed with the app private key for
+// an installation access token.
+func installationToken(ctx context.Context, args *Args) (string, error) {
+	jwt, err := appJWT(args.GitHub.AppID, args.GitHub.AppKey, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := fmt.Sprintf("%s/app/installations/%d/access_tokens", strings.TrimSuffix(args.GitHub.API, "/"), args.GitHub.InstallationID)
+
+	token := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+
+	headers := githubHeaders("Bearer " + jwt)
+	if err := requestJSON(ctx, http.MethodPost, endpoint, headers, nil, &token); err != nil {
+		return "", err
+	}
+
+	if token.Token == "" {
+		return "", fmt.Errorf("token response from %s has no token", endpoint)
+	}
+
+	logrus.Infof("using installation token for app %d expiring at %s\n", args.GitHub.AppID, token.ExpiresAt.Format(time.RFC3339))
+
+	return token.Token, nil
+}
+
+// appJWT returns a JWT authenticating as the app, signed with RS256.
+func appJWT(appID int64, key string, now time.Time) (string, error) {
+	privateKey, err := parseAppKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-appJWTDrift).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": fmt.Sprint(appID),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("could not sign app token: %w", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseAppKey reads the PKCS#1 key GitHub issues, or the PKCS#8 form.
+func parseAppKey(key string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, fmt.Errorf("app private key is not PEM encoded: %w", errConfiguration)
+	}
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse app private key: %w", err)
+	}
+
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("app private key must be an RSA key: %w", errConfiguration)
+	}
+
+	return privateKey, nil
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateApp(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs1Key := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}))
+	pkcs8Key := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+
+	tests := []struct {
+		name        string
+		key         string
+		githubToken string
+		status      int
+		token       string
+		wantToken   string
+		wantErr     bool
+	}{
+		{
+			name:      "pkcs1 key",
+			key:       pkcs1Key,
+			status:    http.StatusCreated,
+			token:     "ghs_installation",
+			wantToken: "ghs_installation",
+		},
+		{
+			name:        "pkcs8 key keeps the configured api token",
+			key:         pkcs8Key,
+			githubToken: "configured",
+			status:      http.StatusCreated,
+			token:       "ghs_installation",
+			wantToken:   "configured",
+		},
+		{
+			name:    "rejected jwt",
+			key:     pkcs1Key,
+			status:  http.StatusUnauthorized,
+			wantErr: true,
+		},
+		{
+			name:    "response without token",
+			key:     pkcs1Key,
+			status:  http.StatusCreated,
+			wantErr: true,
+		},
+		{
+			name:    "key is not pem",
+			key:     "not a key",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/app/installations/99/access_tokens" {
+					http.NotFound(w, r)
+
+					return
+				}
+
+				if err := verifyAppJWT(&privateKey.PublicKey, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), "12"); err != "" {
+					t.Errorf("invalid jwt: %s", err)
+				}
+
+				w.WriteHeader(test.status)
+				_ = json.NewEncoder(w).Encode(map[string]string{"token": test.token, "expires_at": "2030-01-01T00:00:00Z"})
+			}))
+			defer server.Close()
+
+			args := &Args{}
+			args.Key = "ssh key"
+			args.GitHub.API = server.URL
+			args.GitHub.AppID = 12
+			args.GitHub.InstallationID = 99
+			args.GitHub.AppKey = test.key
+			args.GitHub.Token = test.githubToken
+
+			err := authenticateApp(context.Background(), args)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			if test.wantErr {
+				return
+			}
+
+			if args.Key != "" || args.Netrc.Login != appTokenLogin || args.Netrc.Password != test.token {
+				t.Errorf("git credentials not replaced: key %q login %q password %q", args.Key, args.Netrc.Login, args.Netrc.Password)
+			}
+
+			if args.GitHub.Token != test.wantToken {
+				t.Errorf("got api token %q, want %q", args.GitHub.Token, test.wantToken)
+			}
+		})
+	}
+}
+
+// verifyAppJWT checks the signature and claims of an app JWT, returning
+// what is wrong with it.
+func verifyAppJWT(key *rsa.PublicKey, jwt, issuer string) string {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return "not three parts"
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err.Error()
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return err.Error()
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err.Error()
+	}
+
+	claims := struct {
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+		Issuer    string `json:"iss"`
+	}{}
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return err.Error()
+	}
+
+	now := time.Now().Unix()
+
+	switch {
+	case claims.Issuer != issuer:
+		return "issuer " + claims.Issuer
+	case claims.IssuedAt > now:
+		return "issued in the future"
+	case claims.ExpiresAt <= now || claims.ExpiresAt-claims.IssuedAt > int64((10*time.Minute).Seconds()):
+		return "expiry out of range"
+	}
+
+	return ""
+}
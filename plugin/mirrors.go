@@ -0,0 +1,212 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	mirrorPolicyFail = "fail"
+	mirrorPolicyWarn = "warn"
+
+	remotePushed    = "pushed"
+	remoteUnchanged = "unchanged"
+	remoteFailed    = "failed"
+)
+
+// envReference matches credentials given as $NAME or ${NAME}.
+var envReference = regexp.MustCompile(`^\$\{?([A-Za-z_][A-Za-z0-9_]*)\}?$`)
+
+// mirror is an additional remote receiving the pages branch. Credentials
+// may reference environment variables, such as $GITEA_TOKEN, so they can
+// be supplied from secrets.
+type mirror struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Branch   string `json:"branch"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Key      string `json:"ssh_key"`
+	Policy   string `json:"policy"`
+
+	// KeyFile holds the key for the git binary
+	KeyFile string `json:"-"`
+}
+
+// mirrorList decodes the mirrors setting, which is passed as JSON.
+type mirrorList []mirror
+
+func (m *mirrorList) Decode(value string) error {
+	return json.Unmarshal([]byte(value), (*[]mirror)(m))
+}
+
+// remoteResult is the outcome of pushing to a remote, shown in the card.
+type remoteResult struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Branch string `json:"branch"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// verifyMirrors applies the defaults to the mirrors and resolves their
+// credentials.
+func verifyMirrors(args *Args) error {
+	if args.PagesRepo.MirrorPolicy != mirrorPolicyFail && args.PagesRepo.MirrorPolicy != mirrorPolicyWarn {
+		return fmt.Errorf("mirror_policy must be %s or %s: %w", mirrorPolicyFail, mirrorPolicyWarn, errConfiguration)
+	}
+
+	for i := range args.PagesRepo.Mirrors {
+		m := &args.PagesRepo.Mirrors[i]
+
+		uri, err := url.Parse(m.URL)
+		if m.URL == "" || err != nil {
+			return fmt.Errorf("mirror %d needs a valid url: %w", i+1, errConfiguration)
+		}
+
+		if m.Name == "" {
+			m.Name = uri.Hostname()
+		}
+
+		if m.Name == "" {
+			m.Name = "mirror-" + strconv.Itoa(i+1)
+		}
+
+		if m.Policy == "" {
+			m.Policy = args.PagesRepo.MirrorPolicy
+		}
+
+		if m.Policy != mirrorPolicyFail && m.Policy != mirrorPolicyWarn {
+			return fmt.Errorf("policy of mirror %s must be %s or %s: %w", m.Name, mirrorPolicyFail, mirrorPolicyWarn, errConfiguration)
+		}
+
+		m.Username = expandCredential(m.Username)
+		m.Password = expandCredential(m.Password)
+		m.Key = expandCredential(m.Key)
+
+		if m.Password != "" && m.Key != "" {
+			return fmt.Errorf("mirror %s sets both password and ssh_key, choose one auth method: %w", m.Name, errConfiguration)
+		}
+
+		if m.Password != "" && m.Username == "" {
+			return fmt.Errorf("mirror %s needs a username with its password: %w", m.Name, errConfiguration)
+		}
+	}
+
+	return nil
+}
+
+func expandCredential(value string) string {
+	if match := envReference.FindStringSubmatch(value); match != nil {
+		return os.Getenv(match[1])
+	}
+
+	return value
+}
+
+// basicAuthHeader returns the header authenticating a mirror over http.
+func (m *mirror) basicAuthHeader() string {
+	return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(m.Username+":"+m.Password))
+}
+
+// usesMirrorKeys reports whether any mirror is pushed to with an ssh key.
+func usesMirrorKeys(args *Args) bool {
+	for _, m := range args.PagesRepo.Mirrors {
+		if m.Key != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// prepareMirrorKeys writes the ssh keys of the mirrors for the git binary
+// and returns a function removing them.
+func prepareMirrorKeys(args *Args) (func(), error) {
+	dir, err := os.MkdirTemp("", "drone-gh-pages-mirrors")
+	if err != nil {
+		return func() {}, fmt.Errorf("could not create mirror key directory: %w", err)
+	}
+
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	for i := range args.PagesRepo.Mirrors {
+		m := &args.PagesRepo.Mirrors[i]
+		if m.Key == "" {
+			continue
+		}
+
+		m.KeyFile = filepath.Join(dir, "mirror_"+strconv.Itoa(i))
+		if err := os.WriteFile(m.KeyFile, []byte(m.Key), 0o600); err != nil { //nolint:gomnd
+			return cleanup, err
+		}
+	}
+
+	return cleanup, nil
+}
+
+// pushMirrors pushes the branch to every mirror, which also catches up
+// mirrors that missed earlier publishes. Failures of mirrors with the warn
+// policy are reported without failing the publish.
+func pushMirrors(ctx context.Context, args *Args, git gitEngine, status string) ([]remoteResult, error) {
+	results := []remoteResult{{
+		Name:   args.PagesRepo.Name,
+		URL:    redactURL(args.PagesRepo.Remote),
+		Branch: args.PagesRepo.Branch,
+		Status: status,
+	}}
+
+	var failed error
+
+	for i := range args.PagesRepo.Mirrors {
+		m := args.PagesRepo.Mirrors[i]
+		if m.Branch == "" {
+			m.Branch = args.PagesRepo.Branch
+		}
+
+		pushCtx, cancel := phaseContext(ctx, args.Timeout.Push)
+		err := git.Mirror(pushCtx, &m)
+		cancel()
+
+		result := remoteResult{
+			Name:   m.Name,
+			URL:    redactURL(m.URL),
+			Branch: m.Branch,
+			Status: remotePushed,
+		}
+
+		switch {
+		case err == nil:
+			logrus.Infof("pushed to mirror %s\n", m.Name)
+		case m.Policy == mirrorPolicyWarn:
+			logrus.Warningf("could not push to mirror %s: %s\n", m.Name, err)
+		case failed == nil:
+			failed = fmt.Errorf("failed to push to mirror %s: %w", m.Name, err)
+		}
+
+		if err != nil {
+			result.Status = remoteFailed
+			result.Error = secrets.redact(err.Error())
+		}
+
+		results = append(results, result)
+	}
+
+	return results, failed
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestVerifyMirrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		mirrors []mirror
+		env     map[string]string
+		want    []mirror
+		err     error
+	}{
+		{
+			name:    "defaults",
+			mirrors: []mirror{{URL: "https://codeberg.org/octo/pages.git"}},
+			want:    []mirror{{Name: "codeberg.org", URL: "https://codeberg.org/octo/pages.git", Policy: mirrorPolicyFail}},
+		},
+		{
+			name:    "credentials from the environment",
+			mirrors: []mirror{{Name: "backup", URL: "https://git.example.com/pages.git", Username: "octo", Password: "$MIRROR_TOKEN", Policy: mirrorPolicyWarn}},
+			env:     map[string]string{"MIRROR_TOKEN": "token"},
+			want:    []mirror{{Name: "backup", URL: "https://git.example.com/pages.git", Username: "octo", Password: "token", Policy: mirrorPolicyWarn}},
+		},
+		{
+			name:    "missing url",
+			mirrors: []mirror{{Name: "backup"}},
+			err:     errConfiguration,
+		},
+		{
+			name:    "password and key",
+			mirrors: []mirror{{URL: "https://git.example.com/pages.git", Username: "octo", Password: "token", Key: "key"}},
+			err:     errConfiguration,
+		},
+		{
+			name:    "password without username",
+			mirrors: []mirror{{URL: "https://git.example.com/pages.git", Password: "token"}},
+			err:     errConfiguration,
+		},
+		{
+			name:    "unknown policy",
+			mirrors: []mirror{{URL: "https://git.example.com/pages.git", Policy: "ignore"}},
+			err:     errConfiguration,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+
+			args := &Args{}
+			args.PagesRepo.MirrorPolicy = mirrorPolicyFail
+			args.PagesRepo.Mirrors = test.mirrors
+
+			err := verifyMirrors(args)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+
+			if test.err == nil && !reflect.DeepEqual([]mirror(args.PagesRepo.Mirrors), test.want) {
+				t.Errorf("got %+v, want %+v", args.PagesRepo.Mirrors, test.want)
+			}
+		})
+	}
+}
+
+// TestPushMirrors publishes to a bare remote and bare mirrors.
+func TestPushMirrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		branch   string
+		policy   string
+		missing  bool
+		publish  int
+		statuses []string
+		wantErr  bool
+	}{
+		{
+			name:     "mirror receives the branch",
+			publish:  1,
+			statuses: []string{remotePushed, remotePushed},
+		},
+		{
+			name:     "mirror branch is overridden",
+			branch:   "pages",
+			publish:  1,
+			statuses: []string{remotePushed, remotePushed},
+		},
+		{
+			name:     "mirror is caught up without changes",
+			publish:  2,
+			statuses: []string{remoteUnchanged, remotePushed},
+		},
+		{
+			name:     "failed mirror fails the publish",
+			policy:   mirrorPolicyFail,
+			missing:  true,
+			publish:  1,
+			statuses: []string{remotePushed, remoteFailed},
+			wantErr:  true,
+		},
+		{
+			name:     "failed mirror is reported with the warn policy",
+			policy:   mirrorPolicyWarn,
+			missing:  true,
+			publish:  1,
+			statuses: []string{remotePushed, remoteFailed},
+		},
+	}
+
+	for _, engine := range engines {
+		for _, test := range tests {
+			t.Run(engine+"/"+test.name, func(t *testing.T) {
+				remote := newRemote(t)
+
+				target := newRemote(t)
+				if test.missing {
+					target = filepath.Join(t.TempDir(), "missing.git")
+				}
+
+				var (
+					results []remoteResult
+					err     error
+				)
+
+				for i := 0; i < test.publish; i++ {
+					args := publishTestArgs(t, engine, remote, map[string]string{"index.html": "one"})
+					args.PagesRepo.MirrorPolicy = mirrorPolicyFail
+					args.PagesRepo.Mirrors = mirrorList{{URL: target, Branch: test.branch, Policy: test.policy}}
+
+					if err := verifyMirrors(args); err != nil {
+						t.Fatal(err)
+					}
+
+					// Only the last publish reaches the mirror
+					if i < test.publish-1 {
+						args.PagesRepo.Mirrors = nil
+					}
+
+					results, err = process(context.Background(), args, testEngine(t, args))
+				}
+
+				if (err != nil) != test.wantErr {
+					t.Fatalf("got error %v, want error %t", err, test.wantErr)
+				}
+
+				statuses := []string{}
+				for _, result := range results {
+					statuses = append(statuses, result.Status)
+				}
+
+				if !reflect.DeepEqual(statuses, test.statuses) {
+					t.Errorf("got statuses %v, want %v", statuses, test.statuses)
+				}
+
+				if test.missing {
+					return
+				}
+
+				branch := test.branch
+				if branch == "" {
+					branch = "gh-pages"
+				}
+
+				tip, errTip := remoteRef(remote, plumbing.NewBranchReferenceName("gh-pages"))
+				mirrored, errMirrored := remoteRef(target, plumbing.NewBranchReferenceName(branch))
+
+				if errTip != nil || errMirrored != nil || tip != mirrored {
+					t.Errorf("mirror branch %s is at %s, want %s", branch, mirrored, tip)
+				}
+
+				if results[1].Branch != branch {
+					t.Errorf("got mirror branch %s in the results, want %s", results[1].Branch, branch)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	changeAdded    = "added"
+	changeModified = "modified"
+	changeDeleted  = "deleted"
+)
+
+// fileChange is a staged change to a file on the pages branch.
+type fileChange struct {
+	Path    string `json:"path"`
+	Status  string `json:"status"`
+	OldSize int64  `json:"old_size"`
+	NewSize int64  `json:"new_size"`
+}
+
+// publishPlan describes the changes a publish would make.
+type publishPlan struct {
+	Branch   string       `json:"branch"`
+	Target   string       `json:"target_directory"`
+	Added    int          `json:"added"`
+	Modified int          `json:"modified"`
+	Deleted  int          `json:"deleted"`
+	Delta    int64        `json:"delta"`
+	Changes  []fileChange `json:"changes"`
+}
+
+func newPlan(args *Args, changes []fileChange) *publishPlan {
+	plan := &publishPlan{
+		Branch:  args.PagesRepo.Branch,
+		Target:  args.TargetDirectory,
+		Changes: changes,
+	}
+
+	sort.Slice(plan.Changes, func(i, j int) bool {
+		return plan.Changes[i].Path < plan.Changes[j].Path
+	})
+
+	for _, change := range changes {
+		switch change.Status {
+		case changeAdded:
+			plan.Added++
+		case changeModified:
+			plan.Modified++
+		case changeDeleted:
+			plan.Deleted++
+		}
+
+		plan.Delta += change.NewSize - change.OldSize
+	}
+
+	return plan
+}
+
+func writePlan(args *Args, plan *publishPlan) error {
+	for _, change := range plan.Changes {
+		fmt.Fprintf(os.Stdout, "%-8s %s (%+d bytes)\n", change.Status, change.Path, change.NewSize-change.OldSize)
+	}
+
+	logrus.Infof("plan: %d added, %d modified, %d deleted, %+d bytes on branch %s\n",
+		plan.Added, plan.Modified, plan.Deleted, plan.Delta, plan.Branch)
+
+	if args.PlanFile == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(args.PlanFile, data, 0o644); err != nil { //nolint:gomnd,gosec
+		return fmt.Errorf("could not write plan to %s: %w", args.PlanFile, err)
+	}
+
+	logrus.Infof("plan written to %s\n", args.PlanFile)
+
+	return nil
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestNewPlan(t *testing.T) {
+	args := &Args{}
+	args.PagesRepo.Branch = "gh-pages"
+	args.TargetDirectory = "docs"
+
+	plan := newPlan(args, []fileChange{
+		{Path: "docs/old.html", Status: changeDeleted, OldSize: 10},
+		{Path: "docs/index.html", Status: changeModified, OldSize: 5, NewSize: 8},
+		{Path: "docs/new.html", Status: changeAdded, NewSize: 4},
+		{Path: "docs/guide.html", Status: changeAdded, NewSize: 1},
+	})
+
+	want := &publishPlan{
+		Branch:   "gh-pages",
+		Target:   "docs",
+		Added:    2,
+		Modified: 1,
+		Deleted:  1,
+		Delta:    -2,
+		Changes: []fileChange{
+			{Path: "docs/guide.html", Status: changeAdded, NewSize: 1},
+			{Path: "docs/index.html", Status: changeModified, OldSize: 5, NewSize: 8},
+			{Path: "docs/new.html", Status: changeAdded, NewSize: 4},
+			{Path: "docs/old.html", Status: changeDeleted, OldSize: 10},
+		},
+	}
+
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("got %+v, want %+v", plan, want)
+	}
+}
+
+// TestPlanOnly checks a dry run reports the changes against the branch
+// without pushing them.
+func TestPlanOnly(t *testing.T) {
+	for _, engine := range engines {
+		t.Run(engine, func(t *testing.T) {
+			remote := newRemote(t)
+			pushBranch(t, remote, "gh-pages", map[string]string{"index.html": "one", "old.html": "old"})
+
+			tip, err := remoteRef(remote, plumbing.NewBranchReferenceName("gh-pages"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			args := publishTestArgs(t, engine, remote, map[string]string{"index.html": "two!", "new.html": "new"})
+			args.DryRun = true
+			args.PlanFile = filepath.Join(t.TempDir(), "plan.json")
+
+			git := testEngine(t, args)
+
+			output := captureOutput(t, &os.Stdout, func() {
+				_, err = process(context.Background(), args, git)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, line := range []string{"added    new.html (+3 bytes)", "modified index.html (+1 bytes)", "deleted  old.html (-3 bytes)"} {
+				if !strings.Contains(output, line) {
+					t.Errorf("output %q does not contain %q", output, line)
+				}
+			}
+
+			want := &publishPlan{
+				Branch:   "gh-pages",
+				Target:   ".",
+				Added:    1,
+				Modified: 1,
+				Deleted:  1,
+				Delta:    1,
+				Changes: []fileChange{
+					{Path: "index.html", Status: changeModified, OldSize: 3, NewSize: 4},
+					{Path: "new.html", Status: changeAdded, NewSize: 3},
+					{Path: "old.html", Status: changeDeleted, OldSize: 3},
+				},
+			}
+
+			if got := readPlan(t, args.PlanFile); !reflect.DeepEqual(got, want) {
+				t.Errorf("got plan %+v, want %+v", got, want)
+			}
+
+			if got, _ := remoteRef(remote, plumbing.NewBranchReferenceName("gh-pages")); got != tip {
+				t.Errorf("dry run moved the branch to %s", got)
+			}
+		})
+	}
+}
+
+// TestProcessSitesPlanFiles checks every branch gets its own plan file when
+// sites publish to several branches.
+func TestProcessSitesPlanFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		branches []string
+		plans    map[string]string
+	}{
+		{
+			name:     "single branch",
+			branches: []string{"gh-pages", "gh-pages"},
+			plans:    map[string]string{"plan.json": "gh-pages"},
+		},
+		{
+			name:     "several branches",
+			branches: []string{"gh-pages", "docs/v1"},
+			plans:    map[string]string{"plan.gh-pages.json": "gh-pages", "plan.docs-v1.json": "docs/v1"},
+		},
+	}
+
+	for _, engine := range engines {
+		for _, test := range tests {
+			t.Run(engine+"/"+test.name, func(t *testing.T) {
+				remote := newRemote(t)
+				plans := t.TempDir()
+
+				args := publishTestArgs(t, engine, remote, nil)
+				args.DryRun = true
+				args.PlanFile = filepath.Join(plans, "plan.json")
+				args.SiteCommits = siteCommitsCombined
+
+				testEngine(t, args)
+
+				for i, branch := range test.branches {
+					src := t.TempDir()
+					writeFiles(t, src, map[string]string{"index.html": branch})
+
+					target := "."
+					if i > 0 && branch == test.branches[0] {
+						target = "api"
+					}
+
+					args.Sites = append(args.Sites, site{Name: branch, Source: src + "/", Target: target, Branch: branch})
+				}
+
+				if _, err := processSites(context.Background(), args); err != nil {
+					t.Fatal(err)
+				}
+
+				entries, err := os.ReadDir(plans)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				names := []string{}
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+				}
+
+				want := []string{}
+				for name := range test.plans {
+					want = append(want, name)
+				}
+
+				sort.Strings(want)
+
+				if !reflect.DeepEqual(names, want) {
+					t.Fatalf("got plan files %v, want %v", names, want)
+				}
+
+				for name, branch := range test.plans {
+					if plan := readPlan(t, filepath.Join(plans, name)); plan.Branch != branch || plan.Added == 0 || plan.Modified+plan.Deleted != 0 {
+						t.Errorf("got plan %+v in %s, want additions to %s", plan, name, branch)
+					}
+
+					if _, err := remoteRef(remote, plumbing.NewBranchReferenceName(branch)); err == nil {
+						t.Errorf("dry run pushed branch %s", branch)
+					}
+				}
+			})
+		}
+	}
+}
+
+func readPlan(t *testing.T, name string) *publishPlan {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &publishPlan{}
+	if err := json.Unmarshal(data, plan); err != nil {
+		t.Fatal(err)
+	}
+
+	return plan
+}
@@ -6,16 +6,18 @@
 package plugin
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/appleboy/drone-git-push/repo"
 	"github.com/drone/drone-go/drone"
@@ -36,28 +38,130 @@ type (
 		// Lint plugin
 		Lint bool `envconfig:"PLUGIN_LINT" default:"true"`
 
+		// Git engine, either cli or go
+		GitEngine string `envconfig:"PLUGIN_GIT_ENGINE" default:"cli"`
+
+		// Sync engine, either go or rsync
+		SyncEngine string `envconfig:"PLUGIN_SYNC_ENGINE" default:"go"`
+
+		// Dry run stops before committing and reports the changes
+		DryRun   bool   `envconfig:"PLUGIN_DRY_RUN"`
+		PlanFile string `envconfig:"PLUGIN_PLAN_FILE"`
+
 		// Plugin specific
 		Key             string `envconfig:"PLUGIN_SSH_KEY"`
 		PagesDirectory  string `envconfig:"PLUGIN_PAGES_DIRECTORY"`
 		TargetDirectory string `envconfig:"PLUGIN_TARGET_DIRECTORY"`
 
+		// Publisher, either github, gitlab or gitea
+		Publisher   string `envconfig:"PLUGIN_PUBLISHER"`
+		PagesDomain string `envconfig:"PLUGIN_PAGES_DOMAIN"`
+
+		// Deploy method, either branch or artifact. The artifact method
+		// uploads through the artifact service of GitHub Actions, so it only
+		// works inside its runners unless the artifact settings are given
+		DeployMethod string `envconfig:"PLUGIN_DEPLOY_METHOD" default:"branch"`
+
+		Artifact struct {
+			Name         string        `envconfig:"PLUGIN_ARTIFACT_NAME" default:"github-pages"`
+			URL          string        `envconfig:"PLUGIN_ARTIFACT_URL"`
+			Token        string        `envconfig:"PLUGIN_ARTIFACT_TOKEN"`
+			OIDCToken    string        `envconfig:"PLUGIN_OIDC_TOKEN"`
+			Environment  string        `envconfig:"PLUGIN_PAGES_ENVIRONMENT" default:"github-pages"`
+			PollInterval time.Duration `envconfig:"PLUGIN_DEPLOY_POLL_INTERVAL" default:"5s"`
+			Timeout      time.Duration `envconfig:"PLUGIN_DEPLOY_TIMEOUT" default:"10m"`
+		}
+
+		// Sites publish several directories in one step
+		Sites       siteList `envconfig:"PLUGIN_SITES"`
+		SiteCommits string   `envconfig:"PLUGIN_SITE_COMMITS" default:"combined"`
+
 		PagesRepo struct {
-			Remote   string `envconfig:"PLUGIN_REMOTE_URL"`
-			Branch   string `envconfig:"PLUGIN_TARGET_BRANCH"`
-			Name     string `envconfig:"PLUGIN_UPSTREAM_NAME"`
+			Remote string   `envconfig:"PLUGIN_REMOTE_URL"`
+			Branch string   `envconfig:"PLUGIN_TARGET_BRANCH"`
+			Name   string   `envconfig:"PLUGIN_UPSTREAM_NAME"`
+			Seed   []string `envconfig:"PLUGIN_SEED_FILES"`
+
+			// Mirrors receive the branch after the remote
+			Mirrors      mirrorList `envconfig:"PLUGIN_MIRRORS"`
+			MirrorPolicy string     `envconfig:"PLUGIN_MIRROR_POLICY" default:"fail"`
+
 			Checkout string
 		}
 
+		Clone struct {
+			Depth  int    `envconfig:"PLUGIN_DEPTH"`
+			Filter string `envconfig:"PLUGIN_CLONE_FILTER"`
+			Sparse bool   `envconfig:"PLUGIN_SPARSE_CHECKOUT"`
+			Paths  []string
+		}
+
 		Rsync struct {
-			ExcludeCname bool `envconfig:"PLUGIN_EXCLUDE_CNAME"`
-			Delete       bool `envconfig:"PLUGIN_DELETE"`
-			CopyContents bool `envconfig:"PLUGIN_COPY_CONTENTS"`
+			ExcludeCname bool     `envconfig:"PLUGIN_EXCLUDE_CNAME"`
+			Delete       bool     `envconfig:"PLUGIN_DELETE"`
+			CopyContents bool     `envconfig:"PLUGIN_COPY_CONTENTS"`
+			Include      []string `envconfig:"PLUGIN_INCLUDE"`
+			Exclude      []string `envconfig:"PLUGIN_EXCLUDE"`
+			Keep         []string `envconfig:"PLUGIN_KEEP"`
 			Source       string
 			Destination  string
 		}
 
+		Versioned struct {
+			Enabled   bool   `envconfig:"PLUGIN_VERSIONED"`
+			Version   string `envconfig:"PLUGIN_VERSION"`
+			Latest    bool   `envconfig:"PLUGIN_VERSION_LATEST" default:"true"`
+			Stable    bool   `envconfig:"PLUGIN_VERSION_STABLE" default:"true"`
+			Manifest  string `envconfig:"PLUGIN_VERSIONS_MANIFEST" default:"versions.json"`
+			Directory string
+			Aliases   []string
+		}
+
+		Preview struct {
+			Enabled   bool   `envconfig:"PLUGIN_PREVIEW"`
+			Prefix    string `envconfig:"PLUGIN_PREVIEW_PREFIX" default:"pr-"`
+			Directory string
+			Cleanup   bool
+		}
+
+		Prune struct {
+			Pattern   string        `envconfig:"PLUGIN_PRUNE_PATTERN"`
+			Keep      int           `envconfig:"PLUGIN_PRUNE_KEEP"`
+			MaxAge    time.Duration `envconfig:"PLUGIN_PRUNE_MAX_AGE"`
+			Protected []string      `envconfig:"PLUGIN_PRUNE_PROTECTED"`
+		}
+
+		Timeout struct {
+			Clone  time.Duration `envconfig:"PLUGIN_CLONE_TIMEOUT"`
+			Sync   time.Duration `envconfig:"PLUGIN_SYNC_TIMEOUT"`
+			Commit time.Duration `envconfig:"PLUGIN_COMMIT_TIMEOUT"`
+			Push   time.Duration `envconfig:"PLUGIN_PUSH_TIMEOUT"`
+			Fetch  time.Duration `envconfig:"PLUGIN_FETCH_TIMEOUT" default:"30s"`
+		}
+
+		Retry struct {
+			Attempts   int           `envconfig:"PLUGIN_PUSH_RETRIES" default:"3"`
+			Backoff    time.Duration `envconfig:"PLUGIN_PUSH_BACKOFF" default:"2s"`
+			MaxBackoff time.Duration `envconfig:"PLUGIN_PUSH_MAX_BACKOFF" default:"30s"`
+		}
+
+		History struct {
+			Strategy string `envconfig:"PLUGIN_HISTORY" default:"append"`
+			Keep     int
+		}
+
+		Signing struct {
+			Format         string `envconfig:"PLUGIN_SIGNING_FORMAT"`
+			Key            string `envconfig:"PLUGIN_SIGNING_KEY"`
+			Passphrase     string `envconfig:"PLUGIN_SIGNING_PASSPHRASE"`
+			Verify         bool   `envconfig:"PLUGIN_SIGNING_VERIFY" default:"true"`
+			KeyID          string
+			AllowedSigners string
+		}
+
 		PagesCommit struct {
 			Message   string `envconfig:"PLUGIN_MESSAGE"`
+			Template  bool   `envconfig:"PLUGIN_COMMIT_TEMPLATE"`
 			ForcePush bool   `envconfig:"PLUGIN_FORCE_PUSH"`
 			Author    struct {
 				Name  string `envconfig:"PLUGIN_USER_NAME"`
@@ -65,6 +169,43 @@ type (
 			}
 		}
 
+		SSH struct {
+			KnownHosts     string   `envconfig:"PLUGIN_KNOWN_HOSTS"`
+			Fingerprints   []string `envconfig:"PLUGIN_SSH_FINGERPRINTS"`
+			Strict         bool     `envconfig:"PLUGIN_SSH_STRICT_HOST_KEY"`
+			Passphrase     string   `envconfig:"PLUGIN_SSH_PASSPHRASE"`
+			Hosts          []sshHost
+			KnownHostsFile string
+		}
+
+		GitHub struct {
+			API            string `envconfig:"PLUGIN_GITHUB_API_URL" default:"https://api.github.com"`
+			AppID          int64  `envconfig:"PLUGIN_APP_ID"`
+			InstallationID int64  `envconfig:"PLUGIN_APP_INSTALLATION_ID"`
+			AppKey         string `envconfig:"PLUGIN_APP_PRIVATE_KEY"`
+			Token          string `envconfig:"PLUGIN_GITHUB_TOKEN"`
+		}
+
+		Deployment struct {
+			Enabled     bool   `envconfig:"PLUGIN_DEPLOYMENT"`
+			Environment string `envconfig:"PLUGIN_DEPLOYMENT_ENVIRONMENT"`
+		}
+
+		Comment struct {
+			Enabled bool `envconfig:"PLUGIN_PR_COMMENT"`
+			Rev     string
+			Changes []fileChange
+		}
+
+		Verify struct {
+			Enabled  bool          `envconfig:"PLUGIN_VERIFY"`
+			URL      string        `envconfig:"PLUGIN_VERIFY_URL"`
+			Marker   string        `envconfig:"PLUGIN_VERIFY_MARKER" default:".deploy.json"`
+			Paths    []string      `envconfig:"PLUGIN_VERIFY_PATHS"`
+			Interval time.Duration `envconfig:"PLUGIN_VERIFY_INTERVAL" default:"10s"`
+			Timeout  time.Duration `envconfig:"PLUGIN_VERIFY_TIMEOUT" default:"10m"`
+		}
+
 		Netrc struct {
 			Machine  string `envconfig:"PLUGIN_NETRC_MACHINE"`
 			Login    string `envconfig:"PLUGIN_USERNAME"`
@@ -73,44 +214,83 @@ type (
 	}
 )
 
-var errConfiguration = errors.New("configuration error")
+var (
+	errConfiguration = errors.New("configuration error")
+	errPushRejected  = errors.New("push rejected as non-fast-forward")
+)
 
 // Exec executes the plugin.
 func Exec(ctx context.Context, args *Args) error {
 	linter := ""
 
+	// Mask credentials in logs and command output
+	registerSecrets(args)
+	logrus.AddHook(redactHook{})
+
+	issues, warnings := 0, ""
+
 	if args.Lint {
-		issues, warnings := lintArgs(args)
+		issues, warnings = lintArgs(args)
 		linter = fmt.Sprintf("lint: %d issue(s) found\n%s", issues, warnings)
 		logrus.Infof("%s\n", linter)
 	}
 
-	err := verifyArgs(args)
+	// Remove the checkout even when cancelled
+	defer func() {
+		if args.PagesRepo.Checkout != "" {
+			os.RemoveAll(args.PagesRepo.Checkout)
+		}
+	}()
+
+	err := verifyArgs(ctx, args)
 	if err != nil {
 		return fmt.Errorf("error in the configuration: %w", err)
 	}
 
-	// Verify git and rsync are present
-	err = verifyExes(args)
-	if err != nil {
-		return fmt.Errorf("error running executable: %w", err)
+	// Messages read from files are only known now
+	if args.Lint {
+		if commitIssues, commitWarnings := lintCommit(args); commitIssues > 0 {
+			logrus.Infof("%s\n", commitWarnings)
+
+			issues += commitIssues
+			warnings += commitWarnings
+			linter = fmt.Sprintf("lint: %d issue(s) found\n%s", issues, warnings)
+		}
 	}
 
-	// Prepare git config
-	err = prepare(args)
+	if err := resolveCommit(args); err != nil {
+		return fmt.Errorf("error in the configuration: %w", err)
+	}
+
+	// Keys read from files or decrypted are only known now
+	registerSecrets(args)
+
+	publisher, err := newPublisher(args)
 	if err != nil {
-		return fmt.Errorf("error configuring git: %w", err)
+		return fmt.Errorf("error in the configuration: %w", err)
 	}
 
 	// Run the plugin
-	err = process(args)
+	remotes, err := publisher.Publish(ctx)
+	deployment := createDeployment(ctx, args)
+
 	if err != nil {
-		return fmt.Errorf("error during processing: %w", err)
+		deployment.finish(ctx, "", err)
+
+		// Failed mirrors are reported once the branch was pushed
+		if len(remotes) > 0 {
+			if pages, errURL := publisher.URL(); errURL == nil {
+				writeStatusCard(args, pages, "", remotes, linter)
+			}
+		}
+
+		return err
 	}
 
 	// Get pages link
-	pages, err := pagesURL(args)
+	pages, err := publisher.URL()
 	if err != nil {
+		deployment.finish(ctx, "", nil)
 		logrus.Warningf("could not determine location for site, skipping card\n")
 
 		return nil //nolint:nilerr
@@ -118,13 +298,45 @@ func Exec(ctx context.Context, args *Args) error {
 
 	logrus.Infof("publishing at: %s\n", pages)
 
-	// Create the card data
+	preview := ""
+
+	if args.Preview.Directory != "" && !args.Preview.Cleanup {
+		preview = subpageURL(pages, servedPath(publisher, previewPath(args))).String()
+		logrus.Infof("preview at: %s\n", preview)
+	}
+
+	// Pages builds asynchronously so the push is not the end
+	err = verifyDeployment(ctx, args, subpageURL(pages, servedPath(publisher, args.TargetDirectory)))
+
+	if preview != "" {
+		deployment.finish(ctx, preview, err)
+	} else {
+		deployment.finish(ctx, pages.String(), err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error verifying deployment: %w", err)
+	}
+
+	commentPreview(ctx, args, preview)
+
+	writeStatusCard(args, pages, preview, remotes, linter)
+
+	return nil
+}
+
+// writeStatusCard writes the card with the site and the remotes reached.
+func writeStatusCard(args *Args, pages *url.URL, preview string, remotes []remoteResult, linter string) {
 	cardData := struct {
-		URL    string `json:"url"`
-		Linter string `json:"linter"`
+		URL     string         `json:"url"`
+		Preview string         `json:"preview,omitempty"`
+		Remotes []remoteResult `json:"remotes,omitempty"`
+		Linter  string         `json:"linter"`
 	}{
-		URL:    pages.String(),
-		Linter: linter,
+		URL:     pages.String(),
+		Preview: preview,
+		Remotes: remotes,
+		Linter:  linter,
 	}
 
 	data, _ := json.Marshal(cardData)
@@ -133,8 +345,58 @@ func Exec(ctx context.Context, args *Args) error {
 		Data:   data,
 	}
 	writeCard(args.Card.Path, &card)
+}
 
-	return nil
+// publishBranch publishes the pages by pushing them to a branch.
+func publishBranch(ctx context.Context, args *Args) ([]remoteResult, error) {
+	git, err := newGitEngine(args)
+	if err != nil {
+		return nil, fmt.Errorf("error in the configuration: %w", err)
+	}
+
+	// Verify git and rsync are present
+	err = verifyExes(ctx, args, git)
+	if err != nil {
+		return nil, fmt.Errorf("error running executable: %w", err)
+	}
+
+	// Prepare git config
+	cleanup, err := prepare(ctx, args)
+	defer cleanup()
+
+	if err != nil {
+		return nil, fmt.Errorf("error configuring git: %w", err)
+	}
+
+	var remotes []remoteResult
+
+	if len(args.Sites) > 0 {
+		remotes, err = processSites(ctx, args)
+	} else {
+		remotes, err = process(ctx, args, git)
+	}
+
+	if err != nil {
+		return remotes, fmt.Errorf("error during processing: %w", err)
+	}
+
+	return remotes, nil
+}
+
+// publishArtifact publishes the pages through the Pages API.
+func publishArtifact(ctx context.Context, args *Args) (*url.URL, error) {
+	if args.GitHub.AppID != 0 && !args.DryRun {
+		if err := authenticateApp(ctx, args); err != nil {
+			return nil, fmt.Errorf("error configuring github: %w", err)
+		}
+	}
+
+	pages, err := deployArtifact(ctx, args)
+	if err != nil {
+		return nil, fmt.Errorf("error during processing: %w", err)
+	}
+
+	return pages, nil
 }
 
 func lintArgs(args *Args) (issues int, warnings string) {
@@ -167,6 +429,28 @@ func lintArgs(args *Args) (issues int, warnings string) {
 		issues++
 	}
 
+	usesKeys := args.Key != "" || usesMirrorKeys(args)
+
+	if usesKeys && !verifiesHostKeys(args) {
+		warningsBuilder.WriteString("ssh host keys are not verified, set known_hosts, ssh_fingerprints or ssh_strict_host_key\n")
+		issues++
+	}
+
+	if !usesKeys && verifiesHostKeys(args) {
+		warningsBuilder.WriteString("known_hosts, ssh_fingerprints and ssh_strict_host_key have no effect without ssh_key or a mirror ssh_key\n")
+		issues++
+	}
+
+	if args.Key == "" && args.SSH.Passphrase != "" {
+		warningsBuilder.WriteString("ssh_passphrase has no effect without ssh_key\n")
+		issues++
+	}
+
+	if args.GitHub.AppID != 0 && (args.Key != "" || args.Netrc.Password != "") {
+		warningsBuilder.WriteString("app_id replaces key and password, choose one auth method\n")
+		issues++
+	}
+
 	if strings.HasSuffix(args.PagesDirectory, "/") {
 		warningsBuilder.WriteString("remove trailing slash from pages_directory and set copy_contents to `true` to rsync the contents of the directory")
 		issues++
@@ -177,20 +461,154 @@ func lintArgs(args *Args) (issues int, warnings string) {
 		issues++
 	}
 
+	if args.Versioned.Enabled && !args.Rsync.CopyContents {
+		warningsBuilder.WriteString("set copy_contents to `true` when versioned is enabled so the version directory holds the site\n")
+		issues++
+	}
+
+	for _, conflict := range keepConflicts(args) {
+		warningsBuilder.WriteString(fmt.Sprintf("%s is in pages_directory but matches keep so it will not be published\n", conflict))
+		issues++
+	}
+
+	if args.Clone.Sparse && (args.TargetDirectory == "" || args.TargetDirectory == ".") && !args.Preview.Enabled && !args.Versioned.Enabled && len(args.Sites) == 0 {
+		warningsBuilder.WriteString("sparse_checkout has no effect without a target_directory\n")
+		issues++
+	}
+
+	if args.Clone.Sparse && args.Prune.Pattern != "" {
+		warningsBuilder.WriteString("prune only sees directories inside the sparse checkout\n")
+		issues++
+	}
+
+	rewritesHistory := args.History.Strategy != "" && args.History.Strategy != historyAppend
+	concurrent := args.Preview.Enabled || args.Versioned.Enabled || (args.TargetDirectory != "" && args.TargetDirectory != ".")
+
+	if rewritesHistory && concurrent {
+		warningsBuilder.WriteString("history rewrites force push the branch, concurrent publishers into other directories will have their pushes rejected and retried\n")
+		issues++
+	}
+
+	if args.Prune.Pattern != "" && args.Prune.Keep <= 0 && args.Prune.MaxAge <= 0 {
+		warningsBuilder.WriteString("prune_pattern has no effect without prune_keep or prune_max_age\n")
+		issues++
+	}
+
+	if args.Prune.Pattern == "" && (args.Prune.Keep > 0 || args.Prune.MaxAge > 0) {
+		warningsBuilder.WriteString("prune_keep and prune_max_age have no effect without prune_pattern\n")
+		issues++
+	}
+
+	if len(args.Sites) > 0 && (args.PagesDirectory != "" || args.TargetDirectory != "") {
+		warningsBuilder.WriteString("pages_directory and target_directory are ignored when sites are set\n")
+		issues++
+	}
+
+	if len(args.Sites) == 0 && args.SiteCommits != "" && args.SiteCommits != siteCommitsCombined {
+		warningsBuilder.WriteString("site_commits has no effect without sites\n")
+		issues++
+	}
+
+	if args.DeployMethod == deployMethodArtifact && (args.TargetDirectory != "" || args.PagesRepo.Branch != "") {
+		warningsBuilder.WriteString("target_directory and target_branch have no effect with the artifact deploy method\n")
+		issues++
+	}
+
+	if args.DeployMethod == deployMethodArtifact && !actionsRunner(args) {
+		warningsBuilder.WriteString("the artifact deploy method only works inside GitHub Actions runners, or with artifact_url, artifact_token and oidc_token set\n")
+		issues++
+	}
+
+	if args.Deployment.Enabled && args.DeployMethod == deployMethodArtifact {
+		warningsBuilder.WriteString("deployment has no effect with the artifact deploy method as the Pages API records the deployment\n")
+		issues++
+	}
+
+	if !args.Deployment.Enabled && args.Deployment.Environment != "" {
+		warningsBuilder.WriteString("deployment_environment has no effect without deployment\n")
+		issues++
+	}
+
+	if args.Comment.Enabled && !args.Preview.Enabled {
+		warningsBuilder.WriteString("pr_comment has no effect without preview\n")
+		issues++
+	}
+
+	if !args.Verify.Enabled && (args.Verify.URL != "" || len(args.Verify.Paths) > 0) {
+		warningsBuilder.WriteString("verify_url and verify_paths have no effect without verify\n")
+		issues++
+	}
+
+	if detected := detectPublisher(args); args.Publisher == "" && detected != "" && detected != publisherGitHub {
+		warningsBuilder.WriteString(fmt.Sprintf("repository is hosted on %[1]s but pages are published for github, set publisher to `%[1]s` to publish for %[1]s\n", detected))
+		issues++
+	}
+
+	// Only GitHub Pages runs jekyll
+	resolved := *args
+	resolvePublisher(&resolved)
+
+	if args.Verify.Enabled && resolved.Publisher == publisherGitHub && args.DeployMethod != deployMethodArtifact && strings.HasPrefix(args.Verify.Marker, ".") {
+		pagesDirectory := args.PagesDirectory
+		if pagesDirectory == "" {
+			pagesDirectory = "docs"
+		}
+
+		if _, err := os.Stat(filepath.Join(pagesDirectory, ".nojekyll")); err != nil {
+			warningsBuilder.WriteString("jekyll does not serve a verify_marker starting with a dot unless pages_directory has a .nojekyll file\n")
+			issues++
+		}
+	}
+
+	if args.Signing.Key == "" && (args.Signing.Passphrase != "" || args.Signing.Format != "") {
+		warningsBuilder.WriteString("signing_passphrase and signing_format have no effect without signing_key\n")
+		issues++
+	}
+
 	return issues, warningsBuilder.String()
 }
 
-func verifyArgs(args *Args) error {
-	if args.Key == "" && args.Netrc.Password == "" {
+func verifyArgs(ctx context.Context, args *Args) error {
+	if args.DeployMethod != deployMethodArtifact && args.Key == "" && args.Netrc.Password == "" && args.GitHub.AppID == 0 {
 		return fmt.Errorf("no authentication method specified: %w", errConfiguration)
 	}
 
+	if args.GitHub.AppID != 0 {
+		if args.GitHub.InstallationID == 0 || args.GitHub.AppKey == "" {
+			return fmt.Errorf("app_id requires app_installation_id and app_private_key: %w", errConfiguration)
+		}
+
+		fetchCtx, cancel := phaseContext(ctx, args.Timeout.Fetch)
+		key, err := contents(fetchCtx, args.GitHub.AppKey)
+		cancel()
+
+		if err != nil {
+			return fmt.Errorf("could not read app_private_key: %w", errConfiguration)
+		}
+
+		if _, err := parseAppKey(key); err != nil {
+			return err
+		}
+
+		args.GitHub.AppKey = key
+	}
+
 	if args.PagesDirectory == "" {
 		args.PagesDirectory = "docs"
 	}
 
-	if args.TargetDirectory == "" {
-		args.TargetDirectory = "."
+	// Publisher
+	resolvePublisher(args)
+
+	publisher, err := newPublisher(args)
+	if err != nil {
+		return err
+	}
+
+	publisher.Defaults()
+
+	if err := publisher.Verify(); err != nil {
+		return err
 	}
 
 	// PagesRepo
@@ -210,10 +628,6 @@ func verifyArgs(args *Args) error {
 		args.PagesRepo.Name = "origin"
 	}
 
-	if args.PagesRepo.Branch == "" {
-		args.PagesRepo.Branch = "gh-pages"
-	}
-
 	tmp, err := os.MkdirTemp("", "drone-gh-pages")
 	if err != nil {
 		return fmt.Errorf("could not create temporary directory: %w", err)
@@ -221,30 +635,28 @@ func verifyArgs(args *Args) error {
 
 	args.PagesRepo.Checkout = tmp
 
-	// PagesCommit
-	if args.PagesCommit.Author.Name == "" {
-		args.PagesCommit.Author.Name = args.Commit.Author.Name
-		if args.PagesCommit.Author.Name == "" {
-			return fmt.Errorf("author name not specified: %w", errConfiguration)
+	// PagesCommit, rendered and defaulted by resolveCommit once linted
+	if args.PagesCommit.Message != "" {
+		fetchCtx, cancel := phaseContext(ctx, args.Timeout.Fetch)
+		args.PagesCommit.Message, err = contents(fetchCtx, args.PagesCommit.Message)
+		cancel()
+
+		if err != nil {
+			return fmt.Errorf("commit message not specified: %w", errConfiguration)
 		}
 	}
 
-	if args.PagesCommit.Author.Email == "" {
-		args.PagesCommit.Author.Email = args.Commit.Author.Email
-		if args.PagesCommit.Author.Email == "" {
-			return fmt.Errorf("author email not specified: %w", errConfiguration)
-		}
+	if filepath.IsAbs(args.TargetDirectory) {
+		return fmt.Errorf("target_directory needs to be relative: %w", errConfiguration)
 	}
 
-	if args.PagesCommit.Message == "" {
-		args.PagesCommit.Message = args.Commit.Message
-		if args.PagesCommit.Message == "" {
-			return fmt.Errorf("commit message not specified: %w", errConfiguration)
-		}
-	} else {
-		args.PagesCommit.Message, err = contents(args.PagesCommit.Message)
-		if err != nil {
-			return fmt.Errorf("commit message not specified: %w", errConfiguration)
+	// Preview and Versioned
+	switch {
+	case args.Preview.Enabled && args.PullRequest.Number > 0:
+		resolvePreview(args)
+	case args.Versioned.Enabled:
+		if err := resolveVersion(args); err != nil {
+			return err
 		}
 	}
 
@@ -257,9 +669,13 @@ func verifyArgs(args *Args) error {
 
 		args.Rsync.Source = filepath.Join(wd, args.PagesDirectory)
 
-		_, err = os.Stat(args.Rsync.Source)
-		if err != nil {
-			return fmt.Errorf("could not get pages directory: %w", err)
+		// Nothing is synced when removing a preview, and sites have their
+		// own sources
+		if !args.Preview.Cleanup && len(args.Sites) == 0 {
+			_, err = os.Stat(args.Rsync.Source)
+			if err != nil {
+				return fmt.Errorf("could not get pages directory: %w", err)
+			}
 		}
 	} else {
 		args.Rsync.Source = args.PagesDirectory
@@ -269,327 +685,570 @@ func verifyArgs(args *Args) error {
 		args.Rsync.Source += "/"
 	}
 
-	if filepath.IsAbs(args.TargetDirectory) {
-		return fmt.Errorf("target_directory needs to be relative: %w", errConfiguration)
-	}
-
 	args.Rsync.Destination = filepath.Join(args.PagesRepo.Checkout, args.TargetDirectory)
 
+	// Prune
+	if args.Prune.Pattern != "" {
+		if _, err := filepath.Match(args.Prune.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid prune_pattern %s: %w", args.Prune.Pattern, errConfiguration)
+		}
+	}
+
 	// Netrc
 	args.Netrc.Machine = remoteURI.Hostname()
 
-	return nil
-}
+	if args.GitEngine != engineCLI && args.GitEngine != engineGo {
+		return fmt.Errorf("git_engine must be %s or %s: %w", engineCLI, engineGo, errConfiguration)
+	}
 
-func verifyExes(args *Args) error {
-	err := gitVersion(args)
-	if err != nil {
-		fmt.Errorf("git not available: %w", err)
+	// History
+	switch strategy := args.History.Strategy; {
+	case strategy == historyAppend || strategy == historyAmend:
+	case strategy == historySingle:
+		args.History.Keep = 1
+	case strings.HasPrefix(strategy, historyKeepLast):
+		keep, err := strconv.Atoi(strings.TrimPrefix(strategy, historyKeepLast))
+		if err != nil || keep < 1 {
+			return fmt.Errorf("history %s needs a positive count: %w", strategy, errConfiguration)
+		}
+
+		args.History.Keep = keep
+	default:
+		return fmt.Errorf("history must be append, amend, single or keep-last-N: %w", errConfiguration)
 	}
 
-	err = rsyncVersion(args)
-	if err != nil {
-		fmt.Errorf("rsync not available: %w", err)
+	// Clone
+	if args.Clone.Depth < 0 {
+		return fmt.Errorf("depth cannot be negative: %w", errConfiguration)
 	}
 
-	return nil
-}
+	if args.Clone.Depth > 0 && args.History.Keep >= args.Clone.Depth {
+		return fmt.Errorf("depth must be larger than the history being kept: %w", errConfiguration)
+	}
 
-func prepare(args *Args) error {
-	if args.Netrc.Login != "" && args.Netrc.Password != "" {
-		if err := repo.WriteNetrc(args.Netrc.Machine, args.Netrc.Login, args.Netrc.Password); err != nil {
-			return fmt.Errorf("failed to write netrc: %w", err)
+	// Ages come from the history, which shallow and partial clones lack
+	if args.Prune.MaxAge > 0 && (args.Clone.Depth > 0 || args.Clone.Filter != "") {
+		return fmt.Errorf("prune_max_age cannot be used with depth or clone_filter: %w", errConfiguration)
+	}
+
+	if args.Clone.Filter != "" && args.GitEngine == engineGo {
+		return fmt.Errorf("clone_filter requires the %s git engine: %w", engineCLI, errConfiguration)
+	}
+
+	// go-git drops the paths left out of a sparse checkout from the index,
+	// so its commits would delete them
+	if args.Clone.Sparse && args.GitEngine == engineGo {
+		return fmt.Errorf("sparse_checkout requires the %s git engine: %w", engineCLI, errConfiguration)
+	}
+
+	if args.Clone.Sparse && filepath.Clean(args.TargetDirectory) != "." {
+		args.Clone.Paths = sparsePaths(args)
+	}
+
+	if args.Retry.Attempts < 0 || args.Retry.Backoff <= 0 || args.Retry.MaxBackoff < args.Retry.Backoff {
+		return fmt.Errorf("push retries need a positive backoff no larger than the max backoff: %w", errConfiguration)
+	}
+
+	if args.SyncEngine != syncGo && args.SyncEngine != syncRsync {
+		return fmt.Errorf("sync_engine must be %s or %s: %w", syncGo, syncRsync, errConfiguration)
+	}
+
+	// Deploy method
+	if err := verifyDeployMethod(args); err != nil {
+		return err
+	}
+
+	// Deployment
+	if args.Deployment.Enabled && args.DeployMethod == deployMethodBranch {
+		if args.Publisher != publisherGitHub {
+			return fmt.Errorf("deployment requires the %s publisher: %w", publisherGitHub, errConfiguration)
 		}
 
-		logrus.Infof("using netrc file for authentication: machine %s login %s\n", args.Netrc.Machine, args.Netrc.Login)
+		if args.GitHub.Token == "" && args.GitHub.AppID == 0 {
+			return fmt.Errorf("github_token or app_id required for deployment: %w", errConfiguration)
+		}
 	}
 
-	if args.Key != "" {
-		if err := repo.WriteKey(args.Key); err != nil {
-			return fmt.Errorf("failed to write ssh key: %w", err)
+	// Comment
+	if args.Comment.Enabled {
+		if args.Publisher != publisherGitHub {
+			return fmt.Errorf("pr_comment requires the %s publisher: %w", publisherGitHub, errConfiguration)
 		}
 
-		logrus.Infof("using ssh key for authentication\n")
+		if args.GitHub.Token == "" && args.GitHub.AppID == 0 {
+			return fmt.Errorf("github_token or app_id required for pr_comment: %w", errConfiguration)
+		}
+	}
+
+	// Verify
+	if args.Verify.Enabled {
+		if len(args.Sites) > 0 {
+			return fmt.Errorf("verify requires a single site: %w", errConfiguration)
+		}
+
+		if args.Verify.Interval <= 0 {
+			return fmt.Errorf("verify_interval must be positive: %w", errConfiguration)
+		}
+
+		if filepath.IsAbs(args.Verify.Marker) || strings.HasPrefix(filepath.Clean(args.Verify.Marker), "..") {
+			return fmt.Errorf("verify_marker needs to be inside the target directory: %w", errConfiguration)
+		}
+	}
+
+	// Mirrors
+	if err := verifyMirrors(args); err != nil {
+		return err
+	}
+
+	// SSH, for the remote and the mirrors pushed to with keys
+	if args.Key != "" || usesMirrorKeys(args) {
+		if err := verifySSH(ctx, args, remoteURI); err != nil {
+			return err
+		}
+	}
+
+	// Sites
+	if len(args.Sites) > 0 {
+		if err := verifySites(args, publisher.Root()); err != nil {
+			return err
+		}
+	}
+
+	// Signing
+	if args.Signing.Key != "" {
+		args.Signing.Format = signingFormat(args)
+
+		if args.Signing.Format != signingOpenPGP && args.Signing.Format != signingSSH {
+			return fmt.Errorf("signing_format must be %s or %s: %w", signingOpenPGP, signingSSH, errConfiguration)
+		}
 	}
 
 	return nil
 }
 
-func process(args *Args) error {
-	defer os.RemoveAll(args.PagesRepo.Checkout)
+// resolveCommit renders the commit settings when commit_template is set
+// and falls back to the author and message of the commit being built.
+func resolveCommit(args *Args) error {
+	var err error
 
-	if err := cloneTarget(args); err != nil {
-		return fmt.Errorf("failed to clone target: %w", err)
+	if args.PagesCommit.Template && args.PagesCommit.Author.Name != "" {
+		args.PagesCommit.Author.Name, err = renderTemplate("user_name", args.PagesCommit.Author.Name, &args.Pipeline)
+		if err != nil {
+			return fmt.Errorf("invalid user_name template: %w", err)
+		}
 	}
 
-	if err := gitCommitAuthor(args); err != nil {
-		return fmt.Errorf("failed to set author to %s: %w", args.PagesCommit.Author.Name, err)
+	if args.PagesCommit.Template && args.PagesCommit.Author.Email != "" {
+		args.PagesCommit.Author.Email, err = renderTemplate("user_email", args.PagesCommit.Author.Email, &args.Pipeline)
+		if err != nil {
+			return fmt.Errorf("invalid user_email template: %w", err)
+		}
 	}
 
-	if err := gitCommitEmail(args); err != nil {
-		return fmt.Errorf("failed to set email to %s: %w", args.PagesCommit.Author.Email, err)
+	if args.PagesCommit.Template && args.PagesCommit.Message != "" {
+		args.PagesCommit.Message, err = renderTemplate("message", args.PagesCommit.Message, &args.Pipeline)
+		if err != nil {
+			return fmt.Errorf("invalid message template: %w", err)
+		}
 	}
 
-	logrus.Infof("committing as: %s <%s>\n", args.PagesCommit.Author.Name, args.PagesCommit.Author.Email)
-
-	if err := rsyncPages(args); err != nil {
-		return fmt.Errorf("failed to sync pages: %w", err)
+	if args.PagesCommit.Author.Name == "" {
+		args.PagesCommit.Author.Name = args.Commit.Author.Name
+		if args.PagesCommit.Author.Name == "" {
+			return fmt.Errorf("author name not specified: %w", errConfiguration)
+		}
 	}
 
-	if dirtyRepo(args) {
-		if err := stageChanges(args); err != nil {
-			return fmt.Errorf("failed to stage changes: %w", err)
+	if args.PagesCommit.Author.Email == "" {
+		args.PagesCommit.Author.Email = args.Commit.Author.Email
+		if args.PagesCommit.Author.Email == "" {
+			return fmt.Errorf("author email not specified: %w", errConfiguration)
 		}
+	}
 
-		if err := commitChanges(args); err != nil {
-			return fmt.Errorf("failed to commit changes: %w", err)
+	if args.PagesCommit.Message == "" {
+		args.PagesCommit.Message = args.Commit.Message
+		if args.PagesCommit.Message == "" {
+			return fmt.Errorf("commit message not specified: %w", errConfiguration)
 		}
+	}
+
+	return nil
+}
+
+func verifyExes(ctx context.Context, args *Args, git gitEngine) error {
+	err := git.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("git not available: %w", err)
+	}
 
-		if err := pushChanges(args); err != nil {
-			return fmt.Errorf("failed to push changes: %w", err)
+	if args.SyncEngine == syncRsync {
+		err = rsyncVersion(ctx, args)
+		if err != nil {
+			return fmt.Errorf("rsync not available: %w", err)
 		}
-	} else {
-		logrus.Infof("no changes detected on branch\n")
 	}
 
 	return nil
 }
 
-func cloneTarget(args *Args) error {
-	clone := []string{
-		"clone",
+// prepare writes the credentials used by the git binary and returns a
+// function removing them again.
+func prepare(ctx context.Context, args *Args) (func(), error) {
+	written := []string{}
+	removers := []func(){}
+	cleanup := func() {
+		for _, remove := range removers {
+			remove()
+		}
+
+		for _, path := range written {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				logrus.Warningf("could not remove %s: %s\n", path, err)
+			}
+		}
+	}
+
+	if args.GitHub.AppID != 0 {
+		if err := authenticateApp(ctx, args); err != nil {
+			return cleanup, err
+		}
 	}
 
-	if args.SkipVerify {
-		clone = append(
-			clone,
-			"--config",
-			"http.sslVerify=false",
-		)
+	// Host keys are checked for every ssh remote, mirrors included
+	if args.Key != "" || usesMirrorKeys(args) {
+		remove, err := prepareKnownHosts(ctx, args)
+		removers = append(removers, remove)
 
-		logrus.Warningf("ssl verification is turned off")
+		if err != nil {
+			return cleanup, fmt.Errorf("failed to prepare known hosts: %w", err)
+		}
 	}
 
-	clone = append(
-		clone,
-		"--branch",
-		args.PagesRepo.Branch,
-		"--origin",
-		args.PagesRepo.Name,
-		"--single-branch",
-		args.PagesRepo.Remote,
-		args.PagesRepo.Checkout,
-	)
+	// The go engine passes credentials directly
+	if args.GitEngine == engineGo {
+		logrus.Infof("using %s engine for git operations\n", args.GitEngine)
 
-	cmd := exec.Command(
-		"git",
-		clone...,
-	)
+		return cleanup, nil
+	}
 
-	return runCommand(cmd)
-}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return cleanup, fmt.Errorf("could not determine home directory: %w", err)
+	}
 
-func rsyncVersion(args *Args) error {
-	cmd := exec.Command(
-		"rsync",
-		"--version",
-	)
-	cmd.Dir = args.PagesRepo.Checkout
+	if args.Netrc.Login != "" && args.Netrc.Password != "" {
+		written = append(written, filepath.Join(home, ".netrc"))
 
-	return runCommand(cmd)
-}
+		if err := repo.WriteNetrc(args.Netrc.Machine, args.Netrc.Login, args.Netrc.Password); err != nil {
+			return cleanup, fmt.Errorf("failed to write netrc: %w", err)
+		}
 
-func rsyncPages(args *Args) error {
-	rysnc := []string{
-		"-r",
-		"--exclude",
-		".git",
+		logrus.Infof("using netrc file for authentication: machine %s login %s\n", args.Netrc.Machine, args.Netrc.Login)
 	}
 
-	if args.Rsync.ExcludeCname {
-		rysnc = append(
-			rysnc,
-			"--exclude",
-			"CNAME",
-		)
+	if args.Key != "" {
+		written = append(written, filepath.Join(home, ".ssh", "id_rsa"), filepath.Join(home, ".ssh", "config"))
+
+		if err := repo.WriteKey(args.Key); err != nil {
+			return cleanup, fmt.Errorf("failed to write ssh key: %w", err)
+		}
+
+		if args.SSH.KnownHostsFile != "" {
+			if err := writeSSHConfig(args, filepath.Join(home, ".ssh", "config")); err != nil {
+				return cleanup, fmt.Errorf("failed to write ssh config: %w", err)
+			}
+		}
+
+		logrus.Infof("using ssh key for authentication\n")
 	}
 
-	if args.Rsync.Delete {
-		rysnc = append(
-			rysnc,
-			"--delete",
-		)
+	if len(args.PagesRepo.Mirrors) > 0 {
+		remove, err := prepareMirrorKeys(args)
+		removers = append(removers, remove)
+
+		if err != nil {
+			return cleanup, fmt.Errorf("failed to write mirror keys: %w", err)
+		}
 	}
 
-	rysnc = append(
-		rysnc,
-		args.Rsync.Source,
-		args.Rsync.Destination,
-	)
+	if args.Signing.Key != "" {
+		remove, err := prepareSigning(ctx, args)
+		removers = append(removers, remove)
 
-	cmd := exec.Command(
-		"rsync",
-		rysnc...,
-	)
+		if err != nil {
+			return cleanup, fmt.Errorf("failed to prepare signing key: %w", err)
+		}
+	}
 
-	return runCommand(cmd)
+	return cleanup, nil
 }
 
-func gitVersion(args *Args) error {
-	cmd := exec.Command(
-		"git",
-		"version",
-	)
-	cmd.Dir = args.PagesRepo.Checkout
+func process(ctx context.Context, args *Args, git gitEngine) ([]remoteResult, error) {
+	backoff := args.Retry.Backoff
 
-	return runCommand(cmd)
-}
+	for attempt := 1; ; attempt++ {
+		remotes, err := publish(ctx, args, git)
+		if !errors.Is(err, errPushRejected) || attempt > args.Retry.Attempts {
+			return remotes, err
+		}
 
-func gitCommitAuthor(args *Args) error {
-	cmd := exec.Command(
-		"git",
-		"config",
-		"user.name",
-		args.PagesCommit.Author.Name,
-	)
-	cmd.Dir = args.PagesRepo.Checkout
+		// Jitter keeps concurrent publishers from retrying in lockstep
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1)) //nolint:gosec
 
-	return runCommand(cmd)
-}
+		logrus.Warningf("push rejected on attempt %d of %d, retrying in %s\n", attempt, args.Retry.Attempts+1, delay.Round(time.Millisecond))
 
-func gitCommitEmail(args *Args) error {
-	cmd := exec.Command(
-		"git",
-		"config",
-		"user.email",
-		args.PagesCommit.Author.Email,
-	)
-	cmd.Dir = args.PagesRepo.Checkout
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		backoff = nextBackoff(backoff, args.Retry.MaxBackoff)
+
+		// Start again from the new tip of the branch
+		if err := os.RemoveAll(args.PagesRepo.Checkout); err != nil {
+			return nil, fmt.Errorf("could not reset checkout: %w", err)
+		}
 
-	return runCommand(cmd)
+		if err := os.MkdirAll(args.PagesRepo.Checkout, 0o700); err != nil { //nolint:gomnd
+			return nil, fmt.Errorf("could not reset checkout: %w", err)
+		}
+	}
 }
 
-func stageChanges(args *Args) error {
-	cmd := exec.Command(
-		"git",
-		"add",
-		".",
-	)
-	cmd.Dir = args.PagesRepo.Checkout
+// nextBackoff doubles the backoff up to the limit.
+func nextBackoff(backoff, limit time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > limit {
+		backoff = limit
+	}
 
-	return runCommand(cmd)
+	return backoff
 }
 
-func commitChanges(args *Args) error {
-	commit := []string{
-		"commit",
-		"-m",
-		args.PagesCommit.Message,
+func publish(ctx context.Context, args *Args, git gitEngine) ([]remoteResult, error) {
+	if err := cloneAndConfigure(ctx, args, git); err != nil {
+		return nil, err
 	}
 
-	cmd := exec.Command(
-		"git",
-		commit...,
+	// A retried publish starts over from the remote
+	args.Comment.Changes = nil
+
+	var (
+		committed bool
+		err       error
 	)
-	cmd.Dir = args.PagesRepo.Checkout
 
-	return runCommand(cmd)
-}
+	if len(args.Sites) > 0 {
+		committed, err = publishSites(ctx, args, git)
+	} else {
+		committed, err = publishSite(ctx, args, git)
+	}
 
-func pushChanges(args *Args) error {
-	cmd := repo.RemotePush(
-		args.PagesRepo.Name,
-		args.PagesRepo.Branch,
-		args.PagesCommit.ForcePush,
-		false,
-	)
-	cmd.Dir = args.PagesRepo.Checkout
+	if err != nil || args.DryRun {
+		return nil, err
+	}
+
+	if args.Comment.Enabled {
+		if args.Comment.Rev, err = git.Head(ctx); err != nil {
+			return nil, fmt.Errorf("failed to read pages commit: %w", err)
+		}
+	}
+
+	if !committed {
+		logrus.Infof("no changes detected on branch\n")
+
+		if len(args.PagesRepo.Mirrors) == 0 {
+			return nil, nil
+		}
 
-	return runCommand(cmd)
+		return pushMirrors(ctx, args, git, remoteUnchanged)
+	}
+
+	pushCtx, cancel := phaseContext(ctx, args.Timeout.Push)
+	defer cancel()
+
+	if err := git.Push(pushCtx); err != nil {
+		return nil, fmt.Errorf("failed to push changes: %w", err)
+	}
+
+	// Mirrors follow once the remote has accepted the commit
+	return pushMirrors(ctx, args, git, remotePushed)
 }
 
-func dirtyRepo(args *Args) bool {
-	cmd := exec.Command(
-		"git",
-		"status",
-		"--porcelain",
-	)
+// publishSite syncs the pages into the checkout and commits them.
+func publishSite(ctx context.Context, args *Args, git gitEngine) (bool, error) {
+	if err := update(ctx, args, git); err != nil {
+		return false, err
+	}
 
-	res := bytes.NewBufferString("")
-	cmd.Dir = args.PagesRepo.Checkout
-	cmd.Stdout = res
-	cmd.Stderr = res
+	commitCtx, cancel := phaseContext(ctx, args.Timeout.Commit)
+	defer cancel()
 
-	err := runCommand(cmd)
-	if err != nil {
-		return false
+	if args.DryRun {
+		return false, planOnly(commitCtx, args, git)
 	}
 
-	if res.Len() > 0 {
-		fmt.Fprintf(os.Stdout, "%s\n", res.String())
+	committed, err := commit(commitCtx, args, git)
+	if err != nil || !committed {
+		return committed, err
+	}
+
+	return true, finishCommit(commitCtx, args, git)
+}
+
+// commit commits the changes in the checkout and reports whether there
+// was anything to commit.
+func commit(ctx context.Context, args *Args, git gitEngine) (bool, error) {
+	if !git.Dirty(ctx) {
+		return false, nil
+	}
+
+	if err := git.Stage(ctx); err != nil {
+		return false, fmt.Errorf("failed to stage changes: %w", err)
+	}
+
+	// The comment summarises what the commits changed
+	if args.Comment.Enabled {
+		changes, err := git.Changes(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to list changes: %w", err)
+		}
+
+		args.Comment.Changes = append(args.Comment.Changes, changes...)
+	}
 
-		return true
+	if err := git.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit changes: %w", err)
 	}
 
-	return false
+	return true, nil
 }
 
-func trace(cmd *exec.Cmd) {
-	fmt.Fprintf(os.Stdout, "+ %s\n", strings.Join(cmd.Args, " "))
+// finishCommit rewrites the history and verifies the signature of the
+// new tip as configured.
+func finishCommit(ctx context.Context, args *Args, git gitEngine) error {
+	if args.History.Keep > 0 {
+		if err := git.Rewrite(ctx, args.History.Keep); err != nil {
+			return fmt.Errorf("failed to rewrite history: %w", err)
+		}
+	}
+
+	if args.Signing.Key != "" && args.Signing.Verify {
+		if err := git.Verify(ctx); err != nil {
+			return fmt.Errorf("failed to verify commit signature: %w", err)
+		}
+	}
+
+	return nil
 }
 
-func runCommand(cmd *exec.Cmd) error {
-	if cmd.Stdout == nil {
-		cmd.Stdout = os.Stdout
+func cloneAndConfigure(ctx context.Context, args *Args, git gitEngine) error {
+	ctx, cancel := phaseContext(ctx, args.Timeout.Clone)
+	defer cancel()
+
+	if err := git.Clone(ctx); err != nil {
+		return fmt.Errorf("failed to clone target: %w", err)
 	}
 
-	if cmd.Stderr == nil {
-		cmd.Stderr = os.Stderr
+	if err := git.Author(ctx); err != nil {
+		return fmt.Errorf("failed to set author to %s <%s>: %w", args.PagesCommit.Author.Name, args.PagesCommit.Author.Email, err)
 	}
 
-	trace(cmd)
+	logrus.Infof("committing as: %s <%s>\n", args.PagesCommit.Author.Name, args.PagesCommit.Author.Email)
 
-	return cmd.Run()
+	return nil
 }
 
-func pagesURL(args *Args) (*url.URL, error) {
-	// See if a CNAME file is present
-	if cname, err := os.ReadFile("CNAME"); err == nil {
-		uri, errp := url.Parse(string(cname))
-		if errp != nil {
-			return nil, fmt.Errorf("could not parse link in cname file: %w", errp)
+// update applies the pages to the checkout.
+func update(ctx context.Context, args *Args, git gitEngine) error {
+	ctx, cancel := phaseContext(ctx, args.Timeout.Sync)
+	defer cancel()
+
+	if args.Preview.Cleanup {
+		if err := removePreview(args); err != nil {
+			return fmt.Errorf("failed to remove preview: %w", err)
 		}
+	} else if err := syncPages(ctx, args); err != nil {
+		return fmt.Errorf("failed to sync pages: %w", err)
+	}
 
-		return uri, nil
+	if writesDeployMarker(args) && !args.Preview.Cleanup {
+		if err := writeDeployMarker(args); err != nil {
+			return fmt.Errorf("failed to write deploy marker: %w", err)
+		}
 	}
 
-	// Determine url from repo information
-	if args.Repo.Link == "" {
-		return nil, fmt.Errorf("repo link not present: %w", errConfiguration)
+	if args.Versioned.Directory != "" {
+		if err := publishVersion(args); err != nil {
+			return fmt.Errorf("failed to publish version: %w", err)
+		}
+	}
+
+	if args.Prune.Pattern != "" {
+		if err := pruneDirectories(ctx, args, git); err != nil {
+			return fmt.Errorf("failed to prune directories: %w", err)
+		}
 	}
 
-	uri, err := url.Parse(args.Repo.Link)
+	return nil
+}
+
+func planOnly(ctx context.Context, args *Args, git gitEngine) error {
+	logrus.Infof("dry run, changes will not be committed or pushed\n")
+
+	if err := git.Stage(ctx); err != nil {
+		return fmt.Errorf("failed to stage changes: %w", err)
+	}
+
+	changes, err := git.Changes(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse repo link: %w", err)
+		return fmt.Errorf("failed to compute changes: %w", err)
 	}
 
-	// Check for GitHub hosting
-	if uri.Hostname() == "github.com" {
-		pages, _ := url.Parse(fmt.Sprintf("https://%s.github.io", args.Repo.Namespace))
+	return writePlan(args, newPlan(args, changes))
+}
+
+// sparsePaths returns the directories written by the publish.
+func sparsePaths(args *Args) []string {
+	paths := []string{filepath.ToSlash(filepath.Clean(args.TargetDirectory))}
 
-		// Check for organization page
-		if pages.Hostname() == args.Repo.Name {
-			return pages, nil
-		}
+	for _, alias := range args.Versioned.Aliases {
+		paths = append(paths, filepath.ToSlash(filepath.Join(filepath.Dir(args.TargetDirectory), alias)))
+	}
 
-		relPages, _ := url.Parse(fmt.Sprintf("./%s", args.Repo.Name))
+	return paths
+}
 
-		return pages.ResolveReference(relPages), nil
+// phaseContext limits a phase to the timeout, when one is set.
+func phaseContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
 
-	// Enterprise hosting
-	uri.Path = ""
-	relPages, _ := url.Parse(fmt.Sprintf("./pages/%s/%s", args.Repo.Namespace, args.Repo.Name))
+	return context.WithTimeout(ctx, timeout)
+}
 
-	return uri.ResolveReference(relPages), nil
+func trace(cmd *exec.Cmd) {
+	fmt.Fprintf(os.Stdout, "+ %s\n", secrets.redact(strings.Join(cmd.Args, " ")))
+}
+
+func runCommand(cmd *exec.Cmd) error {
+	stdout, stderr := newRedactWriter(os.Stdout), newRedactWriter(os.Stderr)
+
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	if cmd.Stdout == nil {
+		cmd.Stdout = stdout
+	}
+
+	if cmd.Stderr == nil {
+		cmd.Stderr = stderr
+	}
+
+	trace(cmd)
+
+	return cmd.Run()
 }
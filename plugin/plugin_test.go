@@ -0,0 +1,255 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff time.Duration
+		limit   time.Duration
+		want    time.Duration
+	}{
+		{name: "doubles", backoff: time.Second, limit: time.Minute, want: 2 * time.Second},
+		{name: "reaches the limit", backoff: 30 * time.Second, limit: time.Minute, want: time.Minute},
+		{name: "capped at the limit", backoff: 45 * time.Second, limit: time.Minute, want: time.Minute},
+		{name: "stays at the limit", backoff: time.Minute, limit: time.Minute, want: time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := nextBackoff(test.backoff, test.limit); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// TestProcessRetry races the publish with another publisher pushing to the
+// branch before each of the first pushes.
+func TestProcessRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		advance  int
+		pushes   int
+		err      error
+		messages []string
+	}{
+		{
+			name:     "rebuilt on top of the other publisher",
+			attempts: 2,
+			advance:  1,
+			pushes:   2,
+			messages: []string{"publish 2", "pushed by another publisher", "publish 1"},
+		},
+		{
+			name:     "rebuilt after every rejection",
+			attempts: 2,
+			advance:  2,
+			pushes:   3,
+			messages: []string{"publish 2", "pushed by another publisher", "pushed by another publisher", "publish 1"},
+		},
+		{
+			name:     "gives up after the retry limit",
+			attempts: 2,
+			advance:  3,
+			pushes:   3,
+			err:      errPushRejected,
+			messages: []string{"pushed by another publisher", "pushed by another publisher", "pushed by another publisher", "publish 1"},
+		},
+		{
+			name:     "not retried without attempts",
+			advance:  1,
+			pushes:   1,
+			err:      errPushRejected,
+			messages: []string{"pushed by another publisher", "publish 1"},
+		},
+	}
+
+	for _, engine := range engines {
+		for _, test := range tests {
+			t.Run(engine+"/"+test.name, func(t *testing.T) {
+				remote := newRemote(t)
+
+				args := publishTestArgs(t, engine, remote, map[string]string{"index.html": "one"})
+				args.PagesCommit.Message = "publish 1"
+
+				if _, err := process(context.Background(), args, testEngine(t, args)); err != nil {
+					t.Fatal(err)
+				}
+
+				args = publishTestArgs(t, engine, remote, map[string]string{"index.html": "two"})
+				args.PagesCommit.Message = "publish 2"
+				args.Retry.Attempts = test.attempts
+
+				git := &advancingEngine{gitEngine: testEngine(t, args), t: t, remote: remote, branch: "gh-pages", advance: test.advance}
+
+				_, err := process(context.Background(), args, git)
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got %v, want %v", err, test.err)
+				}
+
+				if git.pushes != test.pushes {
+					t.Errorf("got %d pushes, want %d", git.pushes, test.pushes)
+				}
+
+				_, messages := branchContents(t, remote, "gh-pages")
+
+				if !reflect.DeepEqual(messages, test.messages) {
+					t.Errorf("got history %q, want %q", messages, test.messages)
+				}
+
+				if test.err != nil {
+					return
+				}
+
+				// The publish commit is rebuilt on the last commit of the other publisher
+				commits := branchCommits(t, remote, "gh-pages")
+				other := commits[1].Hash
+
+				if len(commits[0].ParentHashes) != 1 || commits[0].ParentHashes[0] != other {
+					t.Errorf("got parents %v, want %s", commits[0].ParentHashes, other)
+				}
+
+				if tip, _ := remoteRef(remote, plumbing.NewBranchReferenceName("gh-pages")); tip != commits[0].Hash {
+					t.Errorf("got tip %s, want %s", tip, commits[0].Hash)
+				}
+			})
+		}
+	}
+}
+
+// TestPhaseTimeout checks an expired phase aborts the publish before the
+// remote changes.
+func TestPhaseTimeout(t *testing.T) {
+	tests := []struct {
+		phase   string
+		timeout func(args *Args) *time.Duration
+	}{
+		{phase: "clone", timeout: func(args *Args) *time.Duration { return &args.Timeout.Clone }},
+		{phase: "sync", timeout: func(args *Args) *time.Duration { return &args.Timeout.Sync }},
+		{phase: "push", timeout: func(args *Args) *time.Duration { return &args.Timeout.Push }},
+	}
+
+	for _, engine := range engines {
+		for _, test := range tests {
+			t.Run(engine+"/"+test.phase, func(t *testing.T) {
+				remote := newRemote(t)
+				pushBranch(t, remote, "gh-pages", map[string]string{"index.html": "one"})
+
+				tip, err := remoteRef(remote, plumbing.NewBranchReferenceName("gh-pages"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				args := publishTestArgs(t, engine, remote, map[string]string{"index.html": "two"})
+				*test.timeout(args) = time.Nanosecond
+
+				if _, err := process(context.Background(), args, testEngine(t, args)); !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("got %v, want the %s phase to time out", err, test.phase)
+				}
+
+				if got, _ := remoteRef(remote, plumbing.NewBranchReferenceName("gh-pages")); got != tip {
+					t.Errorf("remote moved to %s after the %s phase timed out", got, test.phase)
+				}
+			})
+		}
+	}
+}
+
+// TestPrepareCleanupOnCancel cancels the publish once the credentials are
+// written and checks the cleanup still removes them.
+func TestPrepareCleanupOnCancel(t *testing.T) {
+	for _, tool := range []string{"git", "gpg"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+
+	t.Setenv("GNUPGHOME", "")
+	os.Unsetenv("GNUPGHOME")
+
+	mirrorKey, _ := sshKeyPair(t)
+	remote := newRemote(t)
+
+	args := publishTestArgs(t, engineCLI, remote, map[string]string{"index.html": "one"})
+	args.Netrc.Machine = "git.example.com"
+	args.Netrc.Login = "octo"
+	args.Netrc.Password = "token"
+	args.PagesRepo.Mirrors = mirrorList{{URL: newRemote(t), Key: mirrorKey, Policy: mirrorPolicyFail}}
+	args.SSH.KnownHosts = "git.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+	args.Signing.Format = signingOpenPGP
+	args.Signing.Key = openPGPTestKey(t)
+
+	git := testEngine(t, args)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cleanup, err := prepare(ctx, args)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	home, _ := os.UserHomeDir()
+	written := []string{
+		filepath.Join(home, ".netrc"),
+		args.SSH.KnownHostsFile,
+		args.PagesRepo.Mirrors[0].KeyFile,
+		os.Getenv("GNUPGHOME"),
+	}
+
+	for _, path := range written[1:] {
+		if _, err := os.Stat(path); err != nil {
+			cleanup()
+			t.Fatalf("%s not prepared: %v", path, err)
+		}
+	}
+
+	_, err = process(ctx, args, &cancellingEngine{gitEngine: git, cancel: cancel})
+	cleanup()
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want the publish to be cancelled", err)
+	}
+
+	for _, path := range written {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", path)
+		}
+	}
+
+	if home, present := os.LookupEnv("GNUPGHOME"); present {
+		t.Errorf("GNUPGHOME left at %s", home)
+	}
+}
+
+// cancellingEngine cancels the publish when the clone starts.
+type cancellingEngine struct {
+	gitEngine
+
+	cancel context.CancelFunc
+}
+
+func (e *cancellingEngine) Clone(ctx context.Context) error {
+	e.cancel()
+
+	return e.gitEngine.Clone(ctx)
+}
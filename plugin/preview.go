@@ -0,0 +1,73 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+func resolvePreview(args *Args) {
+	args.Preview.Directory = fmt.Sprintf("%s%d", args.Preview.Prefix, args.PullRequest.Number)
+	args.TargetDirectory = filepath.Join(args.TargetDirectory, args.Preview.Directory)
+
+	switch args.Build.Action {
+	case "closed", "merged":
+		args.Preview.Cleanup = true
+
+		logrus.Infof("pull request %s, removing preview %s\n", args.Build.Action, args.Preview.Directory)
+	default:
+		logrus.Infof("publishing preview %s\n", args.Preview.Directory)
+	}
+}
+
+func removePreview(args *Args) error {
+	if _, err := os.Stat(args.Rsync.Destination); os.IsNotExist(err) {
+		logrus.Infof("preview %s not present on branch\n", args.Preview.Directory)
+
+		return nil
+	}
+
+	return os.RemoveAll(args.Rsync.Destination)
+}
+
+// subpageURL resolves a directory on the pages branch against the site url.
+func subpageURL(pages *url.URL, dir string) *url.URL {
+	base := *pages
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	rel, _ := url.Parse("./" + path.Clean(filepath.ToSlash(dir)) + "/")
+
+	return base.ResolveReference(rel)
+}
+
+// servedPath returns the path a directory on the branch is served at.
+func servedPath(publisher Publisher, dir string) string {
+	rel, err := filepath.Rel(publisher.Root(), dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return dir
+	}
+
+	return rel
+}
+
+// previewPath returns the directory on the branch holding the preview site.
+// Without copy_contents the source directory itself is copied into it.
+func previewPath(args *Args) string {
+	if args.Rsync.CopyContents {
+		return args.TargetDirectory
+	}
+
+	return filepath.Join(args.TargetDirectory, filepath.Base(filepath.Clean(args.Rsync.Source)))
+}
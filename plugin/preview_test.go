@@ -0,0 +1,131 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePreview(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		action  string
+		want    string
+		cleanup bool
+	}{
+		{name: "opened", target: ".", action: "opened", want: "pr-7"},
+		{name: "in a directory", target: "previews", action: "synchronized", want: "previews/pr-7"},
+		{name: "closed", target: ".", action: "closed", want: "pr-7", cleanup: true},
+		{name: "merged", target: ".", action: "merged", want: "pr-7", cleanup: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.TargetDirectory = test.target
+			args.Preview.Prefix = "pr-"
+			args.PullRequest.Number = 7
+			args.Build.Action = test.action
+
+			resolvePreview(args)
+
+			if args.TargetDirectory != test.want || args.Preview.Cleanup != test.cleanup {
+				t.Errorf("got target %s and cleanup %t", args.TargetDirectory, args.Preview.Cleanup)
+			}
+		})
+	}
+}
+
+func TestRemovePreview(t *testing.T) {
+	checkout := t.TempDir()
+	writeFiles(t, checkout, map[string]string{"index.html": "", "pr-7/index.html": ""})
+
+	args := &Args{}
+	args.Preview.Directory = "pr-7"
+	args.Rsync.Destination = filepath.Join(checkout, "pr-7")
+
+	for i := 0; i < 2; i++ {
+		if err := removePreview(args); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(args.Rsync.Destination); !os.IsNotExist(err) {
+		t.Errorf("preview not removed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(checkout, "index.html")); err != nil {
+		t.Errorf("site removed: %v", err)
+	}
+}
+
+func TestPreviewURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		publisher    string
+		target       string
+		source       string
+		copyContents bool
+		want         string
+	}{
+		{
+			name:         "copy_contents",
+			publisher:    publisherGitHub,
+			target:       "pr-7",
+			source:       "/drone/src/docs/",
+			copyContents: true,
+			want:         "https://octo.github.io/pages/pr-7/",
+		},
+		{
+			name:      "without copy_contents",
+			publisher: publisherGitHub,
+			target:    "pr-7",
+			source:    "/drone/src/docs",
+			want:      "https://octo.github.io/pages/pr-7/docs/",
+		},
+		{
+			name:         "under the gitlab root",
+			publisher:    publisherGitLab,
+			target:       "public/pr-7",
+			source:       "/drone/src/docs/",
+			copyContents: true,
+			want:         "https://octo.github.io/pages/pr-7/",
+		},
+		{
+			name:         "outside the gitlab root",
+			publisher:    publisherGitLab,
+			target:       "pr-7",
+			source:       "/drone/src/docs/",
+			copyContents: true,
+			want:         "https://octo.github.io/pages/pr-7/",
+		},
+	}
+
+	pages, _ := url.Parse("https://octo.github.io/pages")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.Publisher = test.publisher
+			args.TargetDirectory = test.target
+			args.Rsync.Source = test.source
+			args.Rsync.CopyContents = test.copyContents
+
+			publisher, err := newPublisher(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := subpageURL(pages, servedPath(publisher, previewPath(args))).String(); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pruneCandidate is a directory on the pages branch matching the prune pattern.
+type pruneCandidate struct {
+	Path    string
+	Updated time.Time
+}
+
+func pruneDirectories(ctx context.Context, args *Args, git gitEngine) error {
+	matches, err := filepath.Glob(filepath.Join(args.PagesRepo.Checkout, args.Prune.Pattern))
+	if err != nil {
+		return fmt.Errorf("invalid prune pattern: %w", err)
+	}
+
+	now := time.Now()
+	candidates := []pruneCandidate{}
+
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+
+		rel, err := filepath.Rel(args.PagesRepo.Checkout, match)
+		if err != nil {
+			return err
+		}
+
+		if protectedFromPrune(args, rel) {
+			logrus.Debugf("not pruning protected directory %s\n", rel)
+
+			continue
+		}
+
+		updated, err := git.LastUpdated(ctx, rel)
+		if err != nil {
+			return fmt.Errorf("could not determine age of %s: %w", rel, err)
+		}
+
+		candidates = append(candidates, pruneCandidate{
+			Path:    rel,
+			Updated: updated,
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Updated.After(candidates[j].Updated)
+	})
+
+	pruned := []string{}
+
+	for i, candidate := range candidates {
+		expired := args.Prune.MaxAge > 0 && now.Sub(candidate.Updated) > args.Prune.MaxAge
+		excess := args.Prune.Keep > 0 && i >= args.Prune.Keep
+
+		if !expired && !excess {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(args.PagesRepo.Checkout, candidate.Path)); err != nil {
+			return fmt.Errorf("could not remove %s: %w", candidate.Path, err)
+		}
+
+		logrus.Infof("pruned %s (last updated %s)\n", candidate.Path, candidate.Updated.Format(time.RFC3339))
+		pruned = append(pruned, candidate.Path)
+	}
+
+	logrus.Infof("pruned %d of %d directories matching %s\n", len(pruned), len(candidates), args.Prune.Pattern)
+
+	if args.Versioned.Enabled {
+		manifest := filepath.Join(args.PagesRepo.Checkout, args.Versioned.Manifest)
+
+		if err := dropFromManifest(manifest, prunedVersions(args, pruned)); err != nil {
+			return fmt.Errorf("could not update %s: %w", args.Versioned.Manifest, err)
+		}
+	}
+
+	return nil
+}
+
+// prunedVersions returns the versions among the pruned directories so they
+// leave the manifest in the same commit.
+func prunedVersions(args *Args, pruned []string) []string {
+	base := filepath.Dir(filepath.Clean(args.TargetDirectory))
+	versions := []string{}
+
+	for _, path := range pruned {
+		if filepath.Dir(path) == base {
+			versions = append(versions, filepath.Base(path))
+		}
+	}
+
+	return versions
+}
+
+func protectedFromPrune(args *Args, rel string) bool {
+	if rel == ".git" || rel == filepath.Clean(args.TargetDirectory) {
+		return true
+	}
+
+	for _, alias := range args.Versioned.Aliases {
+		if rel == filepath.Join(filepath.Dir(args.TargetDirectory), alias) {
+			return true
+		}
+	}
+
+	for _, entry := range args.Sites {
+		if rel == filepath.Clean(entry.Target) {
+			return true
+		}
+	}
+
+	for _, pattern := range args.Prune.Protected {
+		if matched, _ := filepath.Match(filepath.Clean(pattern), rel); matched {
+			return true
+		}
+	}
+
+	return false
+}
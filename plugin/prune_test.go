@@ -0,0 +1,211 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPruneDirectories(t *testing.T) {
+	now := time.Now()
+	ages := map[string]time.Duration{
+		"pr-1":   30 * 24 * time.Hour,
+		"pr-2":   10 * 24 * time.Hour,
+		"pr-3":   2 * 24 * time.Hour,
+		"pr-4":   time.Hour,
+		"v1.0":   400 * 24 * time.Hour,
+		"v1.1":   200 * 24 * time.Hour,
+		"v2.0":   24 * time.Hour,
+		"stable": 24 * time.Hour,
+	}
+
+	tests := []struct {
+		name      string
+		pattern   string
+		keep      int
+		maxAge    time.Duration
+		protected []string
+		target    string
+		want      []string
+	}{
+		{
+			name:    "keep the newest",
+			pattern: "pr-*",
+			keep:    2,
+			want:    []string{"pr-3", "pr-4", "stable", "v1.0", "v1.1", "v2.0"},
+		},
+		{
+			name:    "remove expired",
+			pattern: "pr-*",
+			maxAge:  7 * 24 * time.Hour,
+			want:    []string{"pr-3", "pr-4", "stable", "v1.0", "v1.1", "v2.0"},
+		},
+		{
+			name:      "protected directories stay",
+			pattern:   "v*",
+			keep:      1,
+			protected: []string{"v1.0"},
+			want:      []string{"pr-1", "pr-2", "pr-3", "pr-4", "stable", "v1.0", "v2.0"},
+		},
+		{
+			name:    "the directory being published stays",
+			pattern: "pr-*",
+			keep:    1,
+			target:  "pr-1",
+			want:    []string{"pr-1", "pr-4", "stable", "v1.0", "v1.1", "v2.0"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkout := t.TempDir()
+			updated := map[string]time.Time{}
+
+			for dir, age := range ages {
+				writeFiles(t, checkout, map[string]string{dir + "/index.html": dir})
+				updated[dir] = now.Add(-age)
+			}
+
+			args := &Args{}
+			args.PagesRepo.Checkout = checkout
+			args.TargetDirectory = test.target
+			args.Prune.Pattern = test.pattern
+			args.Prune.Keep = test.keep
+			args.Prune.MaxAge = test.maxAge
+			args.Prune.Protected = test.protected
+
+			if err := pruneDirectories(context.Background(), args, &agedGit{updated: updated}); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := listDirs(t, checkout); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPruneVersionsFromManifest(t *testing.T) {
+	checkout := t.TempDir()
+	now := time.Now()
+	entries := []versionEntry{
+		{Version: "v1.2", Title: "v1.2", Aliases: []string{aliasLatest}},
+		{Version: "v1.1", Title: "v1.1", Aliases: []string{}},
+		{Version: "v1.0", Title: "v1.0", Aliases: []string{}},
+	}
+
+	writeFiles(t, checkout, map[string]string{
+		"docs/v1.0/index.html":   "v1.0",
+		"docs/v1.1/index.html":   "v1.1",
+		"docs/v1.2/index.html":   "v1.2",
+		"docs/latest/index.html": "v1.2",
+	})
+
+	if err := writeManifest(filepath.Join(checkout, "versions.json"), entries); err != nil {
+		t.Fatal(err)
+	}
+
+	args := &Args{}
+	args.PagesRepo.Checkout = checkout
+	args.TargetDirectory = "docs/v1.2"
+	args.Versioned.Enabled = true
+	args.Versioned.Directory = "v1.2"
+	args.Versioned.Aliases = []string{aliasLatest}
+	args.Versioned.Manifest = "versions.json"
+	args.Prune.Pattern = "docs/v*"
+	args.Prune.Keep = 1
+
+	git := &agedGit{updated: map[string]time.Time{
+		"docs/v1.0": now.Add(-3 * time.Hour),
+		"docs/v1.1": now.Add(-2 * time.Hour),
+	}}
+
+	if err := pruneDirectories(context.Background(), args, git); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readManifest(filepath.Join(checkout, "versions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The published version is protected so the newest other one is kept
+	if !reflect.DeepEqual(got, entries[:2]) {
+		t.Errorf("got manifest %v, want %v", got, entries[:2])
+	}
+
+	if _, err := os.Stat(filepath.Join(checkout, "docs", "v1.0")); !os.IsNotExist(err) {
+		t.Errorf("v1.0 not pruned: %v", err)
+	}
+}
+
+func TestDropFromManifest(t *testing.T) {
+	checkout := t.TempDir()
+	manifest := filepath.Join(checkout, "versions.json")
+
+	if err := dropFromManifest(manifest, []string{"v1.0"}); err != nil {
+		t.Fatalf("missing manifest: %v", err)
+	}
+
+	entries := []versionEntry{
+		{Version: "v1.1", Title: "v1.1", Aliases: []string{aliasLatest}},
+		{Version: "v1.0", Title: "v1.0", Aliases: []string{}},
+	}
+
+	if err := writeManifest(manifest, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dropFromManifest(manifest, []string{"v1.0", "pr-3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, entries[:1]) {
+		t.Errorf("got %v", got)
+	}
+}
+
+// agedGit reports the last update of paths from a table.
+type agedGit struct {
+	gitEngine
+	updated map[string]time.Time
+}
+
+func (g *agedGit) LastUpdated(_ context.Context, path string) (time.Time, error) {
+	return g.updated[filepath.ToSlash(path)], nil
+}
+
+func listDirs(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dirs := []string{}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+
+	sort.Strings(dirs)
+
+	return dirs
+}
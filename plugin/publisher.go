@@ -0,0 +1,397 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	publisherGitHub = "github"
+	publisherGitLab = "gitlab"
+	publisherGitea  = "gitea"
+
+	gitlabRoot     = "public"
+	gitlabPipeline = ".gitlab-ci.yml"
+)
+
+// Publisher publishes the pages to a hosting service and knows where the
+// service serves them.
+type Publisher interface {
+	// Defaults applies the branch and directory conventions of the host
+	// to settings left unset.
+	Defaults()
+
+	// Verify checks the settings are supported by the host.
+	Verify() error
+
+	// Publish publishes the pages and returns the remotes they reached.
+	Publish(ctx context.Context) ([]remoteResult, error)
+
+	// Root returns the directory on the branch served at the site root.
+	Root() string
+
+	// URL returns the address the pages are served at.
+	URL() (*url.URL, error)
+}
+
+func newPublisher(args *Args) (Publisher, error) {
+	switch args.Publisher {
+	case publisherGitHub:
+		return &githubPublisher{args: args}, nil
+	case publisherGitLab:
+		return &gitlabPublisher{args: args}, nil
+	case publisherGitea:
+		return &giteaPublisher{args: args}, nil
+	}
+
+	return nil, fmt.Errorf("publisher must be %s, %s or %s: %w", publisherGitHub, publisherGitLab, publisherGitea, errConfiguration)
+}
+
+// resolvePublisher picks the publisher from the setting, defaulting to
+// GitHub. Publishers change the branch and directory the pages go to, so
+// the host of the repository is only suggested by the linter.
+func resolvePublisher(args *Args) {
+	// Unknown settings are kept so newPublisher reports them
+	if args.Publisher != "" {
+		if name := publisherFor(args.Publisher); name != "" {
+			args.Publisher = name
+		}
+
+		return
+	}
+
+	args.Publisher = publisherGitHub
+}
+
+// detectPublisher returns the publisher matching the host of the
+// repository, if any.
+func detectPublisher(args *Args) string {
+	uri, err := url.Parse(args.Repo.Link)
+	if err != nil {
+		return ""
+	}
+
+	return publisherFor(uri.Hostname())
+}
+
+// publisherFor maps a host or publisher name to its publisher.
+func publisherFor(name string) string {
+	switch name = strings.ToLower(name); {
+	case strings.Contains(name, publisherGitHub):
+		return publisherGitHub
+	case strings.Contains(name, publisherGitLab):
+		return publisherGitLab
+	case strings.Contains(name, publisherGitea), strings.Contains(name, "forgejo"), strings.Contains(name, "codeberg"):
+		return publisherGitea
+	}
+
+	return ""
+}
+
+// customDomain reads the domain the site is served at from the file the
+// host uses to configure it.
+func customDomain(name string) (*url.URL, bool, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, false, nil //nolint:nilerr
+	}
+
+	domain := strings.TrimSpace(strings.SplitN(string(content), "\n", 2)[0]) //nolint:gomnd
+	if !strings.Contains(domain, "://") {
+		domain = "https://" + domain
+	}
+
+	uri, err := url.Parse(domain)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not parse link in %s file: %w", name, err)
+	}
+
+	return uri, true, nil
+}
+
+// repoLink parses the link of the repository being built.
+func repoLink(args *Args) (*url.URL, error) {
+	if args.Repo.Link == "" {
+		return nil, fmt.Errorf("repo link not present: %w", errConfiguration)
+	}
+
+	uri, err := url.Parse(args.Repo.Link)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse repo link: %w", err)
+	}
+
+	return uri, nil
+}
+
+// pagesDomain returns the configured pages domain or the one of the public
+// instance of the host.
+func pagesDomain(args *Args, host, public string) (string, error) {
+	if args.PagesDomain != "" {
+		return args.PagesDomain, nil
+	}
+
+	uri, err := repoLink(args)
+	if err != nil {
+		return "", err
+	}
+
+	if uri.Hostname() != host {
+		return "", fmt.Errorf("pages_domain not specified for %s: %w", uri.Hostname(), errConfiguration)
+	}
+
+	return public, nil
+}
+
+// githubPublisher publishes to a branch or through the Pages API.
+type githubPublisher struct {
+	args     *Args
+	deployed *url.URL
+}
+
+func (p *githubPublisher) Defaults() {
+	if p.args.TargetDirectory == "" {
+		p.args.TargetDirectory = "."
+	}
+
+	if p.args.PagesRepo.Branch == "" {
+		p.args.PagesRepo.Branch = "gh-pages"
+	}
+}
+
+func (p *githubPublisher) Verify() error {
+	return nil
+}
+
+func (p *githubPublisher) Publish(ctx context.Context) ([]remoteResult, error) {
+	if p.args.DeployMethod == deployMethodArtifact {
+		deployed, err := publishArtifact(ctx, p.args)
+		p.deployed = deployed
+
+		return nil, err
+	}
+
+	return publishBranch(ctx, p.args)
+}
+
+func (p *githubPublisher) Root() string {
+	return "."
+}
+
+func (p *githubPublisher) URL() (*url.URL, error) {
+	// Artifact deployments report their own
+	if p.deployed != nil {
+		return p.deployed, nil
+	}
+
+	// See if a CNAME file is present
+	if cname, err := os.ReadFile("CNAME"); err == nil {
+		uri, errp := url.Parse(string(cname))
+		if errp != nil {
+			return nil, fmt.Errorf("could not parse link in cname file: %w", errp)
+		}
+
+		return uri, nil
+	}
+
+	// Determine url from repo information
+	uri, err := repoLink(p.args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for GitHub hosting
+	if uri.Hostname() == "github.com" {
+		pages, _ := url.Parse(fmt.Sprintf("https://%s.github.io", p.args.Repo.Namespace))
+
+		// Check for organization page
+		if pages.Hostname() == p.args.Repo.Name {
+			return pages, nil
+		}
+
+		relPages, _ := url.Parse(fmt.Sprintf("./%s", p.args.Repo.Name))
+
+		return pages.ResolveReference(relPages), nil
+	}
+
+	// Enterprise hosting
+	uri.Path = ""
+	relPages, _ := url.Parse(fmt.Sprintf("./pages/%s/%s", p.args.Repo.Namespace, p.args.Repo.Name))
+
+	return uri.ResolveReference(relPages), nil
+}
+
+// gitlabPublisher pushes the site into the public directory of a branch,
+// which a pages job in the .gitlab-ci.yml of the branch deploys. GitLab
+// Pages only deploys from such a job, so new branches are seeded with one
+// unless a .gitlab-ci.yml seed file is given.
+type gitlabPublisher struct {
+	args *Args
+}
+
+func (p *gitlabPublisher) Defaults() {
+	if p.args.TargetDirectory == "" {
+		p.args.TargetDirectory = gitlabRoot
+	}
+
+	if p.args.PagesRepo.Branch == "" {
+		p.args.PagesRepo.Branch = "pages"
+	}
+}
+
+func (p *gitlabPublisher) Verify() error {
+	if p.args.DeployMethod != deployMethodBranch {
+		return fmt.Errorf("gitlab requires the %s deploy method: %w", deployMethodBranch, errConfiguration)
+	}
+
+	return nil
+}
+
+func (p *gitlabPublisher) Publish(ctx context.Context) ([]remoteResult, error) {
+	cleanup, err := seedGitLabPipeline(p.args)
+	defer cleanup()
+
+	if err != nil {
+		return nil, fmt.Errorf("error seeding pages job: %w", err)
+	}
+
+	return publishBranch(ctx, p.args)
+}
+
+func (p *gitlabPublisher) Root() string {
+	return gitlabRoot
+}
+
+// URL follows the GitLab layout where the top level group is the host and
+// subgroups and the project are the path. Projects named after the host
+// are served at the root.
+func (p *gitlabPublisher) URL() (*url.URL, error) {
+	domain, err := pagesDomain(p.args, "gitlab.com", "gitlab.io")
+	if err != nil {
+		return nil, err
+	}
+
+	groups := strings.Split(strings.ToLower(p.args.Repo.Namespace), "/")
+	host := groups[0] + "." + domain
+
+	project := strings.ToLower(p.args.Repo.Name)
+	if len(groups) == 1 && project == host {
+		project = ""
+	}
+
+	pages := &url.URL{
+		Scheme: "https",
+		Host:   host,
+		Path:   path.Join(append([]string{"/"}, append(groups[1:], project)...)...),
+	}
+
+	if !strings.HasSuffix(pages.Path, "/") {
+		pages.Path += "/"
+	}
+
+	return pages, nil
+}
+
+// seedGitLabPipeline adds a .gitlab-ci.yml deploying the public directory
+// of the branch to the seed files, unless one is seeded already.
+func seedGitLabPipeline(args *Args) (func(), error) {
+	for _, seed := range args.PagesRepo.Seed {
+		if filepath.Base(seed) == gitlabPipeline {
+			return func() {}, nil
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "drone-gh-pages-gitlab-")
+	if err != nil {
+		return func() {}, err
+	}
+
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	pipeline := fmt.Sprintf(`pages:
+  script:
+    - echo "deploying %[1]s"
+  artifacts:
+    paths:
+      - %[1]s
+  rules:
+    - if: $CI_COMMIT_BRANCH == %[2]q
+`, gitlabRoot, args.PagesRepo.Branch)
+
+	name := filepath.Join(dir, gitlabPipeline)
+	if err := os.WriteFile(name, []byte(pipeline), 0o644); err != nil { //nolint:gomnd
+		return cleanup, err
+	}
+
+	args.PagesRepo.Seed = append(args.PagesRepo.Seed, name)
+
+	return cleanup, nil
+}
+
+// giteaPublisher pushes to the pages branch served by Codeberg Pages and
+// other Gitea or Forgejo pages servers.
+type giteaPublisher struct {
+	args *Args
+}
+
+func (p *giteaPublisher) Defaults() {
+	if p.args.TargetDirectory == "" {
+		p.args.TargetDirectory = "."
+	}
+
+	if p.args.PagesRepo.Branch == "" {
+		p.args.PagesRepo.Branch = "pages"
+	}
+}
+
+func (p *giteaPublisher) Verify() error {
+	if p.args.DeployMethod != deployMethodBranch {
+		return fmt.Errorf("gitea requires the %s deploy method: %w", deployMethodBranch, errConfiguration)
+	}
+
+	return nil
+}
+
+func (p *giteaPublisher) Publish(ctx context.Context) ([]remoteResult, error) {
+	return publishBranch(ctx, p.args)
+}
+
+func (p *giteaPublisher) Root() string {
+	return "."
+}
+
+// URL serves the owner at a subdomain and repositories in a path, except
+// the repository named pages which is served at the root.
+func (p *giteaPublisher) URL() (*url.URL, error) {
+	if uri, found, err := customDomain(".domains"); found || err != nil {
+		return uri, err
+	}
+
+	domain, err := pagesDomain(p.args, "codeberg.org", "codeberg.page")
+	if err != nil {
+		return nil, err
+	}
+
+	pages := &url.URL{
+		Scheme: "https",
+		Host:   strings.ToLower(p.args.Repo.Namespace) + "." + domain,
+		Path:   "/",
+	}
+
+	if p.args.Repo.Name != "pages" {
+		pages.Path += p.args.Repo.Name + "/"
+	}
+
+	return pages, nil
+}
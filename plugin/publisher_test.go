@@ -0,0 +1,280 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolvePublisher(t *testing.T) {
+	tests := []struct {
+		name      string
+		publisher string
+		link      string
+		want      string
+	}{
+		{name: "setting wins", publisher: "GitLab", link: "https://github.com/octo/pages", want: publisherGitLab},
+		{name: "unknown setting is kept", publisher: "bitbucket", want: "bitbucket"},
+		{name: "host of the link is not used", link: "https://gitlab.example.com/octo/pages", want: publisherGitHub},
+		{name: "defaults to github", link: "https://git.example.com/octo/pages", want: publisherGitHub},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.Publisher = test.publisher
+			args.Repo.Link = test.link
+
+			resolvePublisher(args)
+
+			if args.Publisher != test.want {
+				t.Errorf("got %s, want %s", args.Publisher, test.want)
+			}
+		})
+	}
+}
+
+func TestLintDetectedPublisher(t *testing.T) {
+	tests := []struct {
+		name      string
+		publisher string
+		link      string
+		want      string
+	}{
+		{name: "gitlab host", link: "https://gitlab.example.com/octo/pages", want: publisherGitLab},
+		{name: "codeberg host", link: "https://codeberg.org/octo/pages", want: publisherGitea},
+		{name: "github host", link: "https://github.com/octo/pages"},
+		{name: "publisher set", publisher: publisherGitLab, link: "https://gitlab.com/octo/pages"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.Publisher = test.publisher
+			args.Repo.Link = test.link
+
+			_, warnings := lintArgs(args)
+
+			want := "set publisher to `" + test.want + "`"
+			if linted := strings.Contains(warnings, "set publisher to"); linted != (test.want != "") || (linted && !strings.Contains(warnings, want)) {
+				t.Errorf("warnings %q, want %q", warnings, test.want)
+			}
+		})
+	}
+}
+
+func TestPublisherDefaults(t *testing.T) {
+	tests := []struct {
+		publisher string
+		target    string
+		branch    string
+		root      string
+	}{
+		{publisher: publisherGitHub, target: ".", branch: "gh-pages", root: "."},
+		{publisher: publisherGitLab, target: gitlabRoot, branch: "pages", root: gitlabRoot},
+		{publisher: publisherGitea, target: ".", branch: "pages", root: "."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.publisher, func(t *testing.T) {
+			args := &Args{}
+			args.Publisher = test.publisher
+
+			publisher, err := newPublisher(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			publisher.Defaults()
+
+			if args.TargetDirectory != test.target || args.PagesRepo.Branch != test.branch || publisher.Root() != test.root {
+				t.Errorf("got target %s, branch %s and root %s", args.TargetDirectory, args.PagesRepo.Branch, publisher.Root())
+			}
+		})
+	}
+}
+
+func TestPublisherURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		publisher string
+		link      string
+		namespace string
+		repo      string
+		domain    string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "github project",
+			publisher: publisherGitHub,
+			link:      "https://github.com/octo/pages",
+			namespace: "octo",
+			repo:      "pages",
+			want:      "https://octo.github.io/pages",
+		},
+		{
+			name:      "github organization",
+			publisher: publisherGitHub,
+			link:      "https://github.com/octo/octo.github.io",
+			namespace: "octo",
+			repo:      "octo.github.io",
+			want:      "https://octo.github.io",
+		},
+		{
+			name:      "github enterprise",
+			publisher: publisherGitHub,
+			link:      "https://git.example.com/octo/pages",
+			namespace: "octo",
+			repo:      "pages",
+			want:      "https://git.example.com/pages/octo/pages",
+		},
+		{
+			name:      "gitlab subgroup",
+			publisher: publisherGitLab,
+			link:      "https://gitlab.com/Octo/docs/pages",
+			namespace: "Octo/docs",
+			repo:      "Pages",
+			want:      "https://octo.gitlab.io/docs/pages/",
+		},
+		{
+			name:      "gitlab group site",
+			publisher: publisherGitLab,
+			link:      "https://gitlab.com/octo/octo.gitlab.io",
+			namespace: "octo",
+			repo:      "octo.gitlab.io",
+			want:      "https://octo.gitlab.io/",
+		},
+		{
+			name:      "gitlab self hosted",
+			publisher: publisherGitLab,
+			link:      "https://gitlab.example.com/octo/pages",
+			namespace: "octo",
+			repo:      "pages",
+			domain:    "pages.example.com",
+			want:      "https://octo.pages.example.com/pages/",
+		},
+		{
+			name:      "gitlab self hosted without pages domain",
+			publisher: publisherGitLab,
+			link:      "https://gitlab.example.com/octo/pages",
+			namespace: "octo",
+			repo:      "pages",
+			wantErr:   true,
+		},
+		{
+			name:      "codeberg project",
+			publisher: publisherGitea,
+			link:      "https://codeberg.org/Octo/docs",
+			namespace: "Octo",
+			repo:      "docs",
+			want:      "https://octo.codeberg.page/docs/",
+		},
+		{
+			name:      "codeberg pages repository",
+			publisher: publisherGitea,
+			link:      "https://codeberg.org/octo/pages",
+			namespace: "octo",
+			repo:      "pages",
+			want:      "https://octo.codeberg.page/",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.Publisher = test.publisher
+			args.Repo.Link = test.link
+			args.Repo.Namespace = test.namespace
+			args.Repo.Name = test.repo
+			args.PagesDomain = test.domain
+
+			publisher, err := newPublisher(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			uri, err := publisher.URL()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			if !test.wantErr && uri.String() != test.want {
+				t.Errorf("got %s, want %s", uri, test.want)
+			}
+		})
+	}
+}
+
+// TestGitLabPublish checks new branches get a pages job deploying the
+// public directory, unless one is seeded.
+func TestGitLabPublish(t *testing.T) {
+	tests := []struct {
+		name   string
+		seeded bool
+		want   string
+	}{
+		{
+			name: "pages job is seeded",
+			want: "pages:\n  script:\n    - echo \"deploying public\"\n  artifacts:\n    paths:\n      - public\n" +
+				"  rules:\n    - if: $CI_COMMIT_BRANCH == \"pages\"\n",
+		},
+		{
+			name:   "seeded pipeline is kept",
+			seeded: true,
+			want:   "pages: {}\n",
+		},
+	}
+
+	for _, engine := range engines {
+		for _, test := range tests {
+			t.Run(engine+"/"+test.name, func(t *testing.T) {
+				remote := newRemote(t)
+
+				args := publishTestArgs(t, engine, remote, map[string]string{"index.html": "one"})
+				args.Publisher = publisherGitLab
+				args.PagesRepo.Branch = "pages"
+				args.TargetDirectory = gitlabRoot
+				args.Rsync.Destination = filepath.Join(args.PagesRepo.Checkout, gitlabRoot)
+
+				if test.seeded {
+					seeds := t.TempDir()
+					writeFiles(t, seeds, map[string]string{gitlabPipeline: test.want})
+					args.PagesRepo.Seed = []string{filepath.Join(seeds, gitlabPipeline)}
+				}
+
+				testEngine(t, args)
+
+				publisher, err := newPublisher(args)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := publisher.Publish(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+
+				files, _ := branchContents(t, remote, "pages")
+				want := map[string]string{gitlabPipeline: test.want, "public/index.html": "one"}
+
+				if !reflect.DeepEqual(files, want) {
+					t.Errorf("got files %q, want %q", files, want)
+				}
+
+				for _, seed := range args.PagesRepo.Seed {
+					if _, err := os.Stat(seed); !test.seeded && !os.IsNotExist(err) {
+						t.Errorf("seeded pipeline %s not removed", seed)
+					}
+				}
+			})
+		}
+	}
+}
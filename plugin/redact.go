@@ -0,0 +1,194 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	redacted = "********"
+
+	// Shorter values would mask unrelated output
+	minSecretLength = 4
+
+	// Output without line breaks is flushed once this large
+	maxRedactBuffer = 64 * 1024
+)
+
+// urlUserinfo matches the userinfo of URLs in free text.
+var urlUserinfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/?#@\s]+@`)
+
+// secrets holds the values masked in logs and command output.
+var secrets = &secretSet{}
+
+type secretSet struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+// add registers secret values, masking multi-line values line by line as
+// well since output is redacted per line.
+func (s *secretSet) add(values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, value := range values {
+		candidates := append([]string{strings.TrimSpace(value)}, strings.Split(value, "\n")...)
+
+		for _, candidate := range candidates {
+			candidate = strings.TrimSpace(candidate)
+			if len(candidate) < minSecretLength || s.contains(candidate) {
+				continue
+			}
+
+			s.values = append(s.values, candidate)
+		}
+	}
+
+	// Longer values first so a line does not break up its whole value
+	sort.SliceStable(s.values, func(i, j int) bool {
+		return len(s.values[i]) > len(s.values[j])
+	})
+}
+
+func (s *secretSet) contains(value string) bool {
+	for _, existing := range s.values {
+		if existing == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redact masks the secrets and strips the userinfo of URLs.
+func (s *secretSet) redact(text string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, value := range s.values {
+		text = strings.ReplaceAll(text, value, redacted)
+	}
+
+	return urlUserinfo.ReplaceAllString(text, "$1")
+}
+
+// registerSecrets adds the credentials in the settings to the secrets.
+func registerSecrets(args *Args) {
+	secrets.add(
+		args.Key,
+		args.Netrc.Password,
+		args.SSH.Passphrase,
+		args.Signing.Key,
+		args.Signing.Passphrase,
+		args.GitHub.AppKey,
+		args.GitHub.Token,
+		args.Artifact.Token,
+		args.Artifact.OIDCToken,
+	)
+
+	remotes := []string{args.PagesRepo.Remote}
+
+	for _, m := range args.PagesRepo.Mirrors {
+		remotes = append(remotes, m.URL)
+		secrets.add(m.Password, m.Key)
+
+		if m.Password != "" {
+			secrets.add(m.basicAuthHeader())
+		}
+	}
+
+	for _, remote := range remotes {
+		if uri, err := url.Parse(remote); err == nil && uri.User != nil {
+			if password, ok := uri.User.Password(); ok {
+				secrets.add(password)
+			}
+		}
+	}
+}
+
+// redactURL returns the URL without its userinfo for logging.
+func redactURL(raw string) string {
+	uri, err := url.Parse(raw)
+	if err != nil || uri.User == nil {
+		return secrets.redact(raw)
+	}
+
+	uri.User = nil
+
+	return uri.String()
+}
+
+// redactHook masks secrets in log entries.
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = secrets.redact(entry.Message)
+
+	return nil
+}
+
+// redactWriter masks secrets in output written to it. Output is redacted
+// per line so secrets split across writes are still found.
+type redactWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+	buf []byte
+}
+
+func newRedactWriter(out io.Writer) *redactWriter {
+	return &redactWriter{out: out}
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	end := bytes.LastIndexAny(w.buf, "\r\n") + 1
+	if len(w.buf) > maxRedactBuffer {
+		end = len(w.buf)
+	}
+
+	if end > 0 {
+		if _, err := io.WriteString(w.out, secrets.redact(string(w.buf[:end]))); err != nil {
+			return 0, err
+		}
+
+		w.buf = append([]byte{}, w.buf[end:]...)
+	}
+
+	return len(p), nil
+}
+
+// Flush writes any output not terminated by a line break.
+func (w *redactWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	_, err := io.WriteString(w.out, secrets.redact(string(w.buf)))
+	w.buf = nil
+
+	return err
+}
@@ -0,0 +1,389 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	signingOpenPGP = "openpgp"
+	signingSSH     = "ssh"
+
+	// SSH signatures follow the sshsig format used by git
+	sshSigMagic     = "SSHSIG"
+	sshSigVersion   = 1
+	sshSigNamespace = "git"
+	sshSigHash      = "sha512"
+)
+
+var errSignature = errors.New("signature verification failed")
+
+// commitSigner signs and verifies commits without the git binary.
+type commitSigner interface {
+	Sign(message io.Reader) ([]byte, error)
+	Verify(signature string, message []byte) error
+}
+
+func newCommitSigner(args *Args) (commitSigner, error) {
+	if args.Signing.Format == signingOpenPGP {
+		entity, err := parseOpenPGPSigningKey(args)
+		if err != nil {
+			return nil, err
+		}
+
+		logrus.Infof("signing commits with openpgp key %X\n", entity.PrimaryKey.Fingerprint)
+
+		return &openPGPSigner{entity: entity}, nil
+	}
+
+	signer, _, err := parseSSHSigningKey(args)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("signing commits with ssh key %s\n", ssh.FingerprintSHA256(signer.PublicKey()))
+
+	return &sshSigner{signer: signer}, nil
+}
+
+// signingFormat infers the signing format from the key when not set.
+func signingFormat(args *Args) string {
+	if args.Signing.Format != "" {
+		return args.Signing.Format
+	}
+
+	if strings.Contains(args.Signing.Key, "BEGIN PGP PRIVATE KEY BLOCK") {
+		return signingOpenPGP
+	}
+
+	return signingSSH
+}
+
+// prepareSigning writes the signing key for the git binary into a
+// temporary directory and returns a function removing it.
+func prepareSigning(ctx context.Context, args *Args) (func(), error) {
+	dir, err := os.MkdirTemp("", "drone-gh-pages-signing")
+	if err != nil {
+		return func() {}, fmt.Errorf("could not create signing directory: %w", err)
+	}
+
+	// git runs gpg with the environment of the plugin, which is restored
+	// once publishing is done
+	previous, present := os.LookupEnv("GNUPGHOME")
+
+	cleanup := func() {
+		if args.Signing.Format == signingOpenPGP {
+			cmd := exec.Command("gpgconf", "--kill", "gpg-agent")
+			cmd.Env = append(os.Environ(), "GNUPGHOME="+dir)
+			_ = cmd.Run()
+
+			if present {
+				os.Setenv("GNUPGHOME", previous)
+			} else {
+				os.Unsetenv("GNUPGHOME")
+			}
+		}
+
+		os.RemoveAll(dir)
+	}
+
+	switch args.Signing.Format {
+	case signingOpenPGP:
+		err = prepareOpenPGP(ctx, args, dir)
+	case signingSSH:
+		err = prepareSSHSigning(args, dir)
+	}
+
+	return cleanup, err
+}
+
+func prepareOpenPGP(ctx context.Context, args *Args, dir string) error {
+	conf := "batch\npinentry-mode loopback\n"
+
+	if args.Signing.Passphrase != "" {
+		passphrase := filepath.Join(dir, "passphrase")
+		if err := os.WriteFile(passphrase, []byte(args.Signing.Passphrase), 0o600); err != nil { //nolint:gomnd
+			return err
+		}
+
+		conf += "passphrase-file " + passphrase + "\n"
+	}
+
+	files := map[string]string{
+		"gpg.conf":       conf,
+		"gpg-agent.conf": "allow-loopback-pinentry\n",
+		"signing.asc":    args.Signing.Key,
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil { //nolint:gomnd
+			return err
+		}
+	}
+
+	os.Setenv("GNUPGHOME", dir)
+
+	cmd := exec.CommandContext(
+		ctx,
+		"gpg",
+		"--import",
+		filepath.Join(dir, "signing.asc"),
+	)
+
+	if err := runCommand(cmd); err != nil {
+		return fmt.Errorf("could not import signing key: %w", err)
+	}
+
+	list := exec.CommandContext(
+		ctx,
+		"gpg",
+		"--with-colons",
+		"--list-secret-keys",
+	)
+
+	res := bytes.NewBufferString("")
+	list.Stdout = res
+
+	if err := runCommand(list); err != nil {
+		return fmt.Errorf("could not list signing key: %w", err)
+	}
+
+	for _, line := range strings.Split(res.String(), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) > 9 && fields[0] == "fpr" {
+			args.Signing.KeyID = fields[9]
+
+			break
+		}
+	}
+
+	if args.Signing.KeyID == "" {
+		return fmt.Errorf("no secret key found in signing key: %w", errConfiguration)
+	}
+
+	logrus.Infof("signing commits with openpgp key %s\n", args.Signing.KeyID)
+
+	return nil
+}
+
+func prepareSSHSigning(args *Args, dir string) error {
+	signer, key, err := parseSSHSigningKey(args)
+	if err != nil {
+		return err
+	}
+
+	// Store the key decrypted so git does not prompt for the passphrase
+	block, err := ssh.MarshalPrivateKey(key, "drone-gh-pages")
+	if err != nil {
+		return fmt.Errorf("could not encode signing key: %w", err)
+	}
+
+	args.Signing.KeyID = filepath.Join(dir, "signing_key")
+	if err := os.WriteFile(args.Signing.KeyID, pem.EncodeToMemory(block), 0o600); err != nil { //nolint:gomnd
+		return err
+	}
+
+	args.Signing.AllowedSigners = filepath.Join(dir, "allowed_signers")
+	allowed := fmt.Sprintf("%s namespaces=\"%s\" %s", args.PagesCommit.Author.Email, sshSigNamespace, ssh.MarshalAuthorizedKey(signer.PublicKey()))
+
+	if err := os.WriteFile(args.Signing.AllowedSigners, []byte(allowed), 0o600); err != nil { //nolint:gomnd
+		return err
+	}
+
+	logrus.Infof("signing commits with ssh key %s\n", ssh.FingerprintSHA256(signer.PublicKey()))
+
+	return nil
+}
+
+func parseSSHSigningKey(args *Args) (ssh.Signer, interface{}, error) {
+	var (
+		key interface{}
+		err error
+	)
+
+	if args.Signing.Passphrase != "" {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(args.Signing.Key), []byte(args.Signing.Passphrase))
+	} else {
+		key, err = ssh.ParseRawPrivateKey([]byte(args.Signing.Key))
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse ssh signing key: %w", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse ssh signing key: %w", err)
+	}
+
+	return signer, key, nil
+}
+
+func parseOpenPGPSigningKey(args *Args) (*openpgp.Entity, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(args.Signing.Key))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse openpgp signing key: %w", err)
+	}
+
+	if len(entities) == 0 || entities[0].PrivateKey == nil {
+		return nil, fmt.Errorf("no secret key found in signing key: %w", errConfiguration)
+	}
+
+	entity := entities[0]
+
+	if args.Signing.Passphrase != "" {
+		if err := entity.DecryptPrivateKeys([]byte(args.Signing.Passphrase)); err != nil {
+			return nil, fmt.Errorf("could not decrypt signing key: %w", err)
+		}
+	}
+
+	return entity, nil
+}
+
+// openPGPSigner signs git objects with an openpgp key.
+type openPGPSigner struct {
+	entity *openpgp.Entity
+}
+
+func (s *openPGPSigner) Sign(message io.Reader) ([]byte, error) {
+	var signature bytes.Buffer
+
+	if err := openpgp.ArmoredDetachSign(&signature, s.entity, message, nil); err != nil {
+		return nil, err
+	}
+
+	return signature.Bytes(), nil
+}
+
+func (s *openPGPSigner) Verify(signature string, message []byte) error {
+	var public bytes.Buffer
+
+	w, err := armor.Encode(&public, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := s.entity.Serialize(w); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(&public)
+	if err != nil {
+		return err
+	}
+
+	_, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(message), strings.NewReader(signature), nil)
+
+	return err
+}
+
+// sshSigner signs git objects with an ssh key.
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+// sshSignedData is the data covered by an ssh signature.
+func sshSignedData(message []byte) []byte {
+	digest := sha512.Sum512(message)
+
+	return append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Namespace string
+		Reserved  string
+		Hash      string
+		Digest    []byte
+	}{
+		Namespace: sshSigNamespace,
+		Hash:      sshSigHash,
+		Digest:    digest[:],
+	})...)
+}
+
+// sshSignature is the envelope of an ssh signature after the magic.
+type sshSignature struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	Hash      string
+	Signature []byte
+}
+
+func (s *sshSigner) Sign(message io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+
+	var signature *ssh.Signature
+
+	// RSA keys must not sign with SHA-1
+	if algorithmSigner, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, sshSignedData(data), ssh.KeyAlgoRSASHA512)
+	} else {
+		signature, err = s.signer.Sign(rand.Reader, sshSignedData(data))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte(sshSigMagic), ssh.Marshal(sshSignature{
+		Version:   sshSigVersion,
+		PublicKey: s.signer.PublicKey().Marshal(),
+		Namespace: sshSigNamespace,
+		Hash:      sshSigHash,
+		Signature: ssh.Marshal(signature),
+	})...)
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "SSH SIGNATURE",
+		Bytes: blob,
+	}), nil
+}
+
+func (s *sshSigner) Verify(armored string, message []byte) error {
+	block, _ := pem.Decode([]byte(armored))
+	if block == nil || block.Type != "SSH SIGNATURE" || !bytes.HasPrefix(block.Bytes, []byte(sshSigMagic)) {
+		return fmt.Errorf("not an ssh signature: %w", errSignature)
+	}
+
+	var envelope sshSignature
+	if err := ssh.Unmarshal(block.Bytes[len(sshSigMagic):], &envelope); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(envelope.PublicKey, s.signer.PublicKey().Marshal()) || envelope.Namespace != sshSigNamespace {
+		return fmt.Errorf("signed by another key: %w", errSignature)
+	}
+
+	signature := new(ssh.Signature)
+	if err := ssh.Unmarshal(envelope.Signature, signature); err != nil {
+		return err
+	}
+
+	return s.signer.PublicKey().Verify(sshSignedData(message), signature)
+}
@@ -0,0 +1,370 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSigningFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		key    string
+		want   string
+	}{
+		{name: "openpgp key", key: openPGPTestKey(t), want: signingOpenPGP},
+		{name: "ssh key", key: sshTestKey(t), want: signingSSH},
+		{name: "format setting wins", format: signingSSH, key: openPGPTestKey(t), want: signingSSH},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.Signing.Format = test.format
+			args.Signing.Key = test.key
+
+			if got := signingFormat(args); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCommitSigner(t *testing.T) {
+	for _, format := range []string{signingOpenPGP, signingSSH} {
+		t.Run(format, func(t *testing.T) {
+			signer := testSigner(t, format)
+			other := testSigner(t, format)
+
+			signature, err := signer.Sign(bytes.NewReader([]byte("tree\n\nmessage\n")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := signer.Verify(string(signature), []byte("tree\n\nmessage\n")); err != nil {
+				t.Errorf("signature not verified: %v", err)
+			}
+
+			if err := signer.Verify(string(signature), []byte("tree\n\nchanged\n")); err == nil {
+				t.Error("signature verified for a changed message")
+			}
+
+			if err := other.Verify(string(signature), []byte("tree\n\nmessage\n")); err == nil {
+				t.Error("signature verified with another key")
+			}
+		})
+	}
+}
+
+func TestSSHSigningKeyPassphrase(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := &Args{}
+	args.Signing.Key = string(pem.EncodeToMemory(block))
+
+	if _, _, err := parseSSHSigningKey(args); err == nil {
+		t.Error("encrypted key parsed without passphrase")
+	}
+
+	args.Signing.Passphrase = "secret"
+
+	if _, _, err := parseSSHSigningKey(args); err != nil {
+		t.Errorf("encrypted key not parsed: %v", err)
+	}
+}
+
+// TestPublishSigned checks every commit on the branch is signed, including
+// the commits recreated by history rewrites, and verifies with the signer
+// of the go engine.
+func TestPublishSigned(t *testing.T) {
+	tests := []struct {
+		name     string
+		history  string
+		keep     int
+		publish  int
+		messages []string
+	}{
+		{
+			name:     "append",
+			history:  historyAppend,
+			publish:  2,
+			messages: []string{"publish 2", "publish 1"},
+		},
+		{
+			name:     "keep last",
+			history:  historyKeepLast + "2",
+			keep:     2,
+			publish:  3,
+			messages: []string{"publish 3", "publish 2"},
+		},
+		{
+			name:     "single",
+			history:  historySingle,
+			keep:     1,
+			publish:  2,
+			messages: []string{"publish 2"},
+		},
+	}
+
+	for _, engine := range engines {
+		for _, format := range []string{signingOpenPGP, signingSSH} {
+			for _, test := range tests {
+				t.Run(engine+"/"+format+"/"+test.name, func(t *testing.T) {
+					remote := newRemote(t)
+					key := signingTestKey(t, format)
+
+					for i := 1; i <= test.publish; i++ {
+						args := publishTestArgs(t, engine, remote, map[string]string{"index.html": strconv.Itoa(i)})
+						args.PagesCommit.Message = "publish " + strconv.Itoa(i)
+						args.History.Strategy = test.history
+						args.History.Keep = test.keep
+						args.Signing.Format = format
+						args.Signing.Key = key
+						args.Signing.Verify = true
+
+						publishSigned(t, args)
+					}
+
+					args := &Args{}
+					args.Signing.Format = format
+					args.Signing.Key = key
+
+					signer, err := newCommitSigner(args)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					messages := []string{}
+
+					for _, commit := range branchCommits(t, remote, "gh-pages") {
+						messages = append(messages, strings.TrimSpace(commit.Message))
+
+						data, err := unsignedCommit(commit)
+						if err != nil {
+							t.Fatal(err)
+						}
+
+						if err := signer.Verify(commit.PGPSignature, data); err != nil {
+							t.Errorf("commit %q is not signed: %v", commit.Message, err)
+						}
+					}
+
+					if !reflect.DeepEqual(messages, test.messages) {
+						t.Errorf("got history %q, want %q", messages, test.messages)
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestVerifyUnsigned checks verification fails for a tip that is not
+// signed by the signing key.
+func TestVerifyUnsigned(t *testing.T) {
+	for _, engine := range engines {
+		for _, format := range []string{signingOpenPGP, signingSSH} {
+			t.Run(engine+"/"+format, func(t *testing.T) {
+				remote := newRemote(t)
+				pushBranch(t, remote, "gh-pages", map[string]string{"index.html": "unsigned"})
+
+				args := publishTestArgs(t, engine, remote, nil)
+				args.Signing.Format = format
+				args.Signing.Key = signingTestKey(t, format)
+				args.Signing.Verify = true
+
+				git := testEngine(t, args)
+				skipWithoutSigningTools(t, args)
+
+				cleanup, err := prepare(context.Background(), args)
+				defer cleanup()
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := cloneAndConfigure(context.Background(), args, git); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := git.Verify(context.Background()); err == nil {
+					t.Error("unsigned commit verified")
+				}
+			})
+		}
+	}
+}
+
+// TestPrepareSigningRestoresGnupgHome checks the keyring used by the git
+// binary does not outlive the publish.
+func TestPrepareSigningRestoresGnupgHome(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+
+	for name, previous := range map[string]string{"unset": "", "set": t.TempDir()} {
+		t.Run(name, func(t *testing.T) {
+			if previous == "" {
+				t.Setenv("GNUPGHOME", "")
+				os.Unsetenv("GNUPGHOME")
+			} else {
+				t.Setenv("GNUPGHOME", previous)
+			}
+
+			args := &Args{}
+			args.Signing.Format = signingOpenPGP
+			args.Signing.Key = openPGPTestKey(t)
+
+			cleanup, err := prepareSigning(context.Background(), args)
+			if err != nil {
+				cleanup()
+				t.Fatal(err)
+			}
+
+			home := os.Getenv("GNUPGHOME")
+			if home == previous || args.Signing.KeyID == "" {
+				t.Errorf("signing key not imported into its own keyring")
+			}
+
+			cleanup()
+
+			if got, present := os.LookupEnv("GNUPGHOME"); got != previous || present != (previous != "") {
+				t.Errorf("got GNUPGHOME %q, want %q", got, previous)
+			}
+
+			if _, err := os.Stat(home); !os.IsNotExist(err) {
+				t.Errorf("keyring %s not removed", home)
+			}
+		})
+	}
+}
+
+// publishSigned publishes with the signing key prepared as for a build.
+func publishSigned(t *testing.T, args *Args) {
+	t.Helper()
+
+	git := testEngine(t, args)
+	skipWithoutSigningTools(t, args)
+
+	cleanup, err := prepare(context.Background(), args)
+	defer cleanup()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := process(context.Background(), args, git); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// skipWithoutSigningTools skips the test when the git binary cannot sign
+// in the format.
+func skipWithoutSigningTools(t *testing.T, args *Args) {
+	t.Helper()
+
+	if args.GitEngine != engineCLI {
+		return
+	}
+
+	tool := "ssh-keygen"
+	if args.Signing.Format == signingOpenPGP {
+		tool = "gpg"
+	}
+
+	if _, err := exec.LookPath(tool); err != nil {
+		t.Skipf("%s not installed", tool)
+	}
+}
+
+func testSigner(t *testing.T, format string) commitSigner {
+	t.Helper()
+
+	args := &Args{}
+	args.Signing.Format = format
+	args.Signing.Key = signingTestKey(t, format)
+
+	signer, err := newCommitSigner(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+func signingTestKey(t *testing.T, format string) string {
+	t.Helper()
+
+	if format == signingOpenPGP {
+		return openPGPTestKey(t)
+	}
+
+	return sshTestKey(t)
+}
+
+func openPGPTestKey(t *testing.T) string {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity("Drone", "", "drone@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var key bytes.Buffer
+
+	w, err := armor.Encode(&key, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return key.String()
+}
+
+func sshTestKey(t *testing.T) string {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(block))
+}
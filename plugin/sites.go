@@ -0,0 +1,289 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	siteCommitsCombined = "combined"
+	siteCommitsPerSite  = "per-site"
+)
+
+// site is one entry of a multi-site publish.
+type site struct {
+	Name    string   `json:"name"`
+	Source  string   `json:"source"`
+	Target  string   `json:"target"`
+	Branch  string   `json:"branch"`
+	Exclude []string `json:"exclude"`
+	Message string   `json:"message"`
+}
+
+// siteList decodes the sites setting, which is passed as JSON.
+type siteList []site
+
+func (s *siteList) Decode(value string) error {
+	return json.Unmarshal([]byte(value), (*[]site)(s))
+}
+
+// verifySites applies the defaults to the sites and resolves the sources.
+// Targets are relative to the root the publisher serves.
+func verifySites(args *Args, root string) error {
+	if args.Preview.Enabled || args.Versioned.Enabled {
+		return fmt.Errorf("sites cannot be combined with preview or versioned: %w", errConfiguration)
+	}
+
+	if args.SiteCommits != siteCommitsCombined && args.SiteCommits != siteCommitsPerSite {
+		return fmt.Errorf("site_commits must be %s or %s: %w", siteCommitsCombined, siteCommitsPerSite, errConfiguration)
+	}
+
+	if args.SiteCommits == siteCommitsPerSite && args.History.Strategy == historyAmend {
+		return fmt.Errorf("site_commits %s cannot be combined with history %s: %w", siteCommitsPerSite, historyAmend, errConfiguration)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("could not get working directory: %w", err)
+	}
+
+	for i := range args.Sites {
+		s := &args.Sites[i]
+
+		if s.Source == "" {
+			return fmt.Errorf("site %d has no source: %w", i+1, errConfiguration)
+		}
+
+		if s.Name == "" {
+			s.Name = s.Source
+		}
+
+		if s.Target == "" {
+			s.Target = "."
+		}
+
+		if filepath.IsAbs(s.Target) {
+			return fmt.Errorf("target of site %s needs to be relative: %w", s.Name, errConfiguration)
+		}
+
+		s.Target = filepath.Join(root, s.Target)
+
+		if s.Branch == "" {
+			s.Branch = args.PagesRepo.Branch
+		}
+
+		if !filepath.IsAbs(s.Source) {
+			s.Source = filepath.Join(wd, s.Source)
+		}
+
+		if _, err := os.Stat(s.Source); err != nil {
+			return fmt.Errorf("could not get source of site %s: %w", s.Name, err)
+		}
+
+		if args.Rsync.CopyContents {
+			s.Source += "/"
+		}
+
+		if args.PagesCommit.Template && s.Message != "" {
+			if s.Message, err = renderTemplate("message", s.Message, &args.Pipeline); err != nil {
+				return fmt.Errorf("invalid message template for site %s: %w", s.Name, err)
+			}
+		}
+	}
+
+	// Every branch would force push over the same mirror branch
+	if order, _ := args.Sites.branches(); len(order) > 1 {
+		for _, m := range args.PagesRepo.Mirrors {
+			if m.Branch != "" {
+				return fmt.Errorf("mirror %s cannot set a branch when sites publish to several branches: %w", m.Name, errConfiguration)
+			}
+		}
+	}
+
+	return nil
+}
+
+// branches returns the sites grouped by branch in the order the branches
+// first appear.
+func (s siteList) branches() ([]string, map[string]siteList) {
+	order := []string{}
+	groups := map[string]siteList{}
+
+	for _, entry := range s {
+		if _, ok := groups[entry.Branch]; !ok {
+			order = append(order, entry.Branch)
+		}
+
+		groups[entry.Branch] = append(groups[entry.Branch], entry)
+	}
+
+	return order, groups
+}
+
+// processSites publishes every branch from its own clone, shared by the
+// sites targeting that branch.
+func processSites(ctx context.Context, args *Args) ([]remoteResult, error) {
+	order, groups := args.Sites.branches()
+	remotes := []remoteResult{}
+
+	for i, branch := range order {
+		branchArgs := *args
+		branchArgs.Sites = groups[branch]
+		branchArgs.PagesRepo.Branch = branch
+		branchArgs.PagesRepo.Checkout = filepath.Join(args.PagesRepo.Checkout, strconv.Itoa(i))
+
+		if err := os.MkdirAll(branchArgs.PagesRepo.Checkout, 0o700); err != nil { //nolint:gomnd
+			return nil, err
+		}
+
+		if args.Clone.Sparse {
+			branchArgs.Clone.Paths = branchArgs.Sites.targets()
+		}
+
+		if args.PlanFile != "" && len(order) > 1 {
+			ext := filepath.Ext(args.PlanFile)
+			branchArgs.PlanFile = strings.TrimSuffix(args.PlanFile, ext) + "." + strings.ReplaceAll(branch, "/", "-") + ext
+		}
+
+		logrus.Infof("publishing %d site(s) to branch %s\n", len(branchArgs.Sites), branch)
+
+		git, err := newGitEngine(&branchArgs)
+		if err != nil {
+			return nil, err
+		}
+
+		results, err := process(ctx, &branchArgs, git)
+		remotes = append(remotes, results...)
+
+		if err != nil {
+			return remotes, fmt.Errorf("branch %s: %w", branch, err)
+		}
+	}
+
+	return remotes, nil
+}
+
+// targets returns the directories written by the sites, or nothing when a
+// site writes the whole branch.
+func (s siteList) targets() []string {
+	paths := []string{}
+
+	for _, entry := range s {
+		target := filepath.ToSlash(filepath.Clean(entry.Target))
+		if target == "." {
+			return nil
+		}
+
+		paths = append(paths, target)
+	}
+
+	return paths
+}
+
+// publishSites syncs every site into the checkout and commits the sites
+// together or one by one.
+func publishSites(ctx context.Context, args *Args, git gitEngine) (bool, error) {
+	commitCtx, cancel := phaseContext(ctx, args.Timeout.Commit)
+	defer cancel()
+
+	message := args.PagesCommit.Message
+	committed := false
+
+	defer func() {
+		args.PagesCommit.Message = message
+	}()
+
+	for _, entry := range args.Sites {
+		logrus.Infof("syncing site %s into %s\n", entry.Name, entry.Target)
+
+		if err := update(ctx, siteArgs(args, entry), git); err != nil {
+			return false, fmt.Errorf("site %s: %w", entry.Name, err)
+		}
+
+		if args.SiteCommits != siteCommitsPerSite || args.DryRun {
+			continue
+		}
+
+		args.PagesCommit.Message = message
+		if entry.Message != "" {
+			args.PagesCommit.Message = entry.Message
+		}
+
+		ok, err := commit(commitCtx, args, git)
+		if err != nil {
+			return false, fmt.Errorf("site %s: %w", entry.Name, err)
+		}
+
+		if !ok {
+			logrus.Infof("no changes detected for site %s\n", entry.Name)
+		}
+
+		committed = committed || ok
+	}
+
+	args.PagesCommit.Message = message
+
+	if args.Prune.Pattern != "" {
+		pruneCtx, cancel := phaseContext(ctx, args.Timeout.Sync)
+		err := pruneDirectories(pruneCtx, args, git)
+		cancel()
+
+		if err != nil {
+			return false, fmt.Errorf("failed to prune directories: %w", err)
+		}
+	}
+
+	if args.DryRun {
+		return false, planOnly(commitCtx, args, git)
+	}
+
+	// Everything when combined, otherwise only what pruning removed
+	ok, err := commit(commitCtx, args, git)
+	if err != nil || !(committed || ok) {
+		return false, err
+	}
+
+	return true, finishCommit(commitCtx, args, git)
+}
+
+// siteArgs returns the settings for syncing a single site.
+func siteArgs(args *Args, entry site) *Args {
+	siteArgs := *args
+	siteArgs.Sites = nil
+	siteArgs.Prune.Pattern = ""
+	siteArgs.PagesDirectory = entry.Source
+	siteArgs.TargetDirectory = entry.Target
+	siteArgs.Rsync.Source = entry.Source
+	siteArgs.Rsync.Destination = filepath.Join(args.PagesRepo.Checkout, entry.Target)
+	siteArgs.Rsync.Exclude = append(append([]string{}, args.Rsync.Exclude...), entry.Exclude...)
+	siteArgs.Rsync.Keep = append([]string{}, args.Rsync.Keep...)
+
+	root := entry.Target
+	if !strings.HasSuffix(entry.Source, "/") {
+		root = filepath.Join(root, filepath.Base(entry.Source))
+	}
+
+	// Deleting must not remove the sites published inside this one
+	for _, other := range args.Sites {
+		rel, err := filepath.Rel(root, other.Target)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		siteArgs.Rsync.Keep = append(siteArgs.Rsync.Keep, "/"+filepath.ToSlash(rel)+"/")
+	}
+
+	return &siteArgs
+}
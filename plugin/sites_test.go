@@ -0,0 +1,111 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVerifySites(t *testing.T) {
+	tests := []struct {
+		name    string
+		root    string
+		sites   []site
+		mirrors []mirror
+		preview bool
+		targets []string
+		err     error
+	}{
+		{
+			name:    "defaults",
+			root:    ".",
+			sites:   []site{{Source: "docs"}, {Source: "api", Target: "api"}},
+			targets: []string{".", "api"},
+		},
+		{
+			name:    "targets are relative to the publisher root",
+			root:    gitlabRoot,
+			sites:   []site{{Source: "docs"}, {Source: "api", Target: "api"}},
+			targets: []string{gitlabRoot, filepath.Join(gitlabRoot, "api")},
+		},
+		{
+			name:  "absolute target",
+			root:  ".",
+			sites: []site{{Source: "docs", Target: "/api"}},
+			err:   errConfiguration,
+		},
+		{
+			name:  "missing source",
+			root:  ".",
+			sites: []site{{Target: "api"}},
+			err:   errConfiguration,
+		},
+		{
+			name:    "combined with preview",
+			root:    ".",
+			sites:   []site{{Source: "docs"}},
+			preview: true,
+			err:     errConfiguration,
+		},
+		{
+			name:    "mirror branch with a single branch",
+			root:    ".",
+			sites:   []site{{Source: "docs"}, {Source: "api", Target: "api"}},
+			mirrors: []mirror{{Name: "backup", Branch: "pages"}},
+			targets: []string{".", "api"},
+		},
+		{
+			name:    "mirror branch with several branches",
+			root:    ".",
+			sites:   []site{{Source: "docs"}, {Source: "api", Branch: "api-pages"}},
+			mirrors: []mirror{{Name: "backup", Branch: "pages"}},
+			err:     errConfiguration,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := t.TempDir()
+			writeFiles(t, src, map[string]string{"docs/index.html": "", "api/index.html": ""})
+
+			args := &Args{}
+			args.PagesRepo.Branch = "gh-pages"
+			args.PagesRepo.Mirrors = test.mirrors
+			args.Preview.Enabled = test.preview
+			args.SiteCommits = siteCommitsCombined
+			args.Sites = siteList{}
+
+			for _, entry := range test.sites {
+				if entry.Source != "" {
+					entry.Source = filepath.Join(src, entry.Source)
+				}
+
+				args.Sites = append(args.Sites, entry)
+			}
+
+			err := verifySites(args, test.root)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+
+			if test.err != nil {
+				return
+			}
+
+			targets := []string{}
+			for _, entry := range args.Sites {
+				targets = append(targets, entry.Target)
+			}
+
+			if !reflect.DeepEqual(targets, test.targets) {
+				t.Errorf("got targets %v, want %v", targets, test.targets)
+			}
+		})
+	}
+}
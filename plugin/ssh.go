@@ -0,0 +1,308 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// forgeFingerprints are the published host key fingerprints of common
+// forges, trusted whenever host keys are verified.
+var forgeFingerprints = map[string][]string{
+	"github.com": {
+		"SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU",
+		"SHA256:p2QAMXNIC1TJYWeIOttrVc98/R1BUFWu3/LiyKgUfQM",
+		"SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s",
+	},
+	"gitlab.com": {
+		"SHA256:eUXGGm1YGsMAS7vkcx6JOJdOGHPem5gQp4taiCfCLB8",
+		"SHA256:HbW3g8zUjNSksFbqTiUWPWg2Bq1x8xdGUrliXFzSnUw",
+		"SHA256:ROQFvPThGrW4RuWLoL9tq9I9zJ42fK4XywyRtbOz/EQ",
+	},
+	"bitbucket.org": {
+		"SHA256:ybgmFkzwOSotHTHLJgHO0QN8L0xErw6vd0VhFA9m3SM",
+		"SHA256:FC73VB6C4OQLSCrjEayhMp9UMxS97caD/Yyi2bhW/J0",
+		"SHA256:46OSHA1Rmj8E8ERTC6xkNcmGOw9oFxYr0WF6zWW8l1E",
+	},
+	"codeberg.org": {
+		"SHA256:mIlxA9k46MmM6qdJOdMnAQpzGxF4WIVVL+fj+wZbw0g",
+		"SHA256:T9FYDEHELhVkulEKKwge5aVhVTbqCW0MIRwAfpARs/E",
+		"SHA256:6QQmYi4ppFS4/+zSZ5S4IU+4sa6rwvQ4PbhCtPEBekQ",
+	},
+}
+
+var errHostKey = errors.New("host key verification failed")
+
+// verifiesHostKeys reports whether host keys are checked at all.
+func verifiesHostKeys(args *Args) bool {
+	return args.SSH.Strict || args.SSH.KnownHosts != "" || len(args.SSH.Fingerprints) > 0
+}
+
+// sshHost is a host pushed to over ssh.
+type sshHost struct {
+	Name string
+	Port string
+}
+
+// verifySSH resolves the hosts pushed to over ssh and the trusted host
+// keys.
+func verifySSH(ctx context.Context, args *Args, remote *url.URL) error {
+	args.SSH.Hosts = sshHosts(args, remote)
+
+	if args.Key != "" && args.SSH.Passphrase != "" {
+		key, err := decryptKey(args.Key, args.SSH.Passphrase)
+		if err != nil {
+			return fmt.Errorf("%s: %w", err, errConfiguration)
+		}
+
+		args.Key = key
+	}
+
+	fetchCtx, cancel := phaseContext(ctx, args.Timeout.Fetch)
+	knownHosts, err := contents(fetchCtx, args.SSH.KnownHosts)
+	cancel()
+
+	if err != nil {
+		return fmt.Errorf("could not read known_hosts: %w", errConfiguration)
+	}
+
+	args.SSH.KnownHosts = knownHosts
+
+	if !verifiesHostKeys(args) {
+		return nil
+	}
+
+	for i, fingerprint := range args.SSH.Fingerprints {
+		if !strings.HasPrefix(fingerprint, "SHA256:") {
+			args.SSH.Fingerprints[i] = "SHA256:" + fingerprint
+		}
+	}
+
+	for _, host := range args.SSH.Hosts {
+		args.SSH.Fingerprints = append(args.SSH.Fingerprints, forgeFingerprints[host.Name]...)
+	}
+
+	if args.SSH.KnownHosts == "" && len(args.SSH.Fingerprints) == 0 {
+		return fmt.Errorf("ssh_strict_host_key needs known_hosts or ssh_fingerprints for %s: %w", hostNames(args.SSH.Hosts), errConfiguration)
+	}
+
+	return nil
+}
+
+// sshHosts returns the hosts pushed to with an ssh key, the remote first
+// followed by the mirrors.
+func sshHosts(args *Args, remote *url.URL) []sshHost {
+	hosts := []sshHost{}
+
+	add := func(uri *url.URL) {
+		host := sshHost{Name: uri.Hostname(), Port: uri.Port()}
+		if host.Port == "" {
+			host.Port = "22"
+		}
+
+		for _, existing := range hosts {
+			if existing == host {
+				return
+			}
+		}
+
+		hosts = append(hosts, host)
+	}
+
+	if args.Key != "" {
+		add(remote)
+	}
+
+	for _, m := range args.PagesRepo.Mirrors {
+		if uri, err := url.Parse(m.URL); err == nil && m.Key != "" {
+			add(uri)
+		}
+	}
+
+	return hosts
+}
+
+func hostNames(hosts []sshHost) string {
+	names := []string{}
+
+	for _, host := range hosts {
+		names = append(names, host.Name)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// decryptKey returns the ssh key without its passphrase so it can be used
+// without a prompt.
+func decryptKey(key, passphrase string) (string, error) {
+	raw, err := ssh.ParseRawPrivateKeyWithPassphrase([]byte(key), []byte(passphrase))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt ssh key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(raw, "")
+	if err != nil {
+		return "", fmt.Errorf("could not encode ssh key: %w", err)
+	}
+
+	return string(pem.EncodeToMemory(block)), nil
+}
+
+// prepareKnownHosts writes the trusted host keys into a known_hosts file
+// and returns a function removing it.
+func prepareKnownHosts(ctx context.Context, args *Args) (func(), error) {
+	dir, err := os.MkdirTemp("", "drone-gh-pages-ssh")
+	if err != nil {
+		return func() {}, fmt.Errorf("could not create known_hosts directory: %w", err)
+	}
+
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	entries := args.SSH.KnownHosts
+
+	// The git binary only reads known_hosts so resolve the fingerprints
+	if len(args.SSH.Fingerprints) > 0 && args.GitEngine == engineCLI {
+		scanned, err := scanHostKeys(ctx, args)
+		if err != nil {
+			return cleanup, err
+		}
+
+		entries += "\n" + scanned
+	}
+
+	args.SSH.KnownHostsFile = filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(args.SSH.KnownHostsFile, []byte(entries+"\n"), 0o600); err != nil { //nolint:gomnd
+		return cleanup, err
+	}
+
+	return cleanup, nil
+}
+
+// scanHostKeys returns the known_hosts entries of the ssh hosts matching
+// the pinned fingerprints.
+func scanHostKeys(ctx context.Context, args *Args) (string, error) {
+	matched := []string{}
+
+	for _, host := range args.SSH.Hosts {
+		cmd := exec.CommandContext(
+			ctx,
+			"ssh-keyscan",
+			"-p",
+			host.Port,
+			host.Name,
+		)
+
+		res := bytes.NewBufferString("")
+		cmd.Stdout = res
+
+		if err := runCommand(cmd); err != nil {
+			return "", fmt.Errorf("could not scan host keys of %s: %w", host.Name, err)
+		}
+
+		found := false
+		scanner := bufio.NewScanner(res)
+
+		for scanner.Scan() {
+			_, _, key, _, _, err := ssh.ParseKnownHosts(scanner.Bytes())
+			if err != nil {
+				continue
+			}
+
+			if pinnedHostKey(args, key) {
+				matched = append(matched, scanner.Text())
+				found = true
+			}
+		}
+
+		// The host may still be listed in known_hosts
+		if !found {
+			logrus.Warningf("no host key of %s matches ssh_fingerprints\n", host.Name)
+		}
+	}
+
+	if len(matched) == 0 && args.SSH.KnownHosts == "" {
+		return "", fmt.Errorf("no host key of %s matches ssh_fingerprints: %w", hostNames(args.SSH.Hosts), errHostKey)
+	}
+
+	return strings.Join(matched, "\n"), nil
+}
+
+// writeSSHConfig replaces the ssh config written with the key so the git
+// binary verifies host keys against the known_hosts file.
+func writeSSHConfig(args *Args, path string) error {
+	config := fmt.Sprintf("Host *\nStrictHostKeyChecking %s\nUserKnownHostsFile %s\n", hostKeyChecking(args), args.SSH.KnownHostsFile)
+
+	return os.WriteFile(path, []byte(config), 0o600) //nolint:gomnd
+}
+
+// hostKeyChecking returns the StrictHostKeyChecking mode of the git binary.
+// Unknown hosts are accepted unless keys are pinned or checking is strict.
+func hostKeyChecking(args *Args) string {
+	if args.SSH.Strict || len(args.SSH.Fingerprints) > 0 {
+		return "yes"
+	}
+
+	return "accept-new"
+}
+
+func pinnedHostKey(args *Args, key ssh.PublicKey) bool {
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	for _, pinned := range args.SSH.Fingerprints {
+		if pinned == fingerprint {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hostKeyCallback verifies host keys for the go engine the same way the
+// ssh config does for the git binary.
+func hostKeyCallback(args *Args) (ssh.HostKeyCallback, error) {
+	known, err := knownhosts.New(args.SSH.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read known_hosts: %w", err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if pinnedHostKey(args, key) {
+			return nil
+		}
+
+		err := known(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		// Unknown hosts are only refused in strict mode
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 && !args.SSH.Strict && len(args.SSH.Fingerprints) == 0 {
+			logrus.Warningf("accepting unknown host key %s for %s\n", ssh.FingerprintSHA256(key), hostname)
+
+			return nil
+		}
+
+		return fmt.Errorf("host key %s for %s is not trusted: %w", ssh.FingerprintSHA256(key), hostname, errHostKey)
+	}, nil
+}
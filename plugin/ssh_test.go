@@ -0,0 +1,418 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestVerifySSH(t *testing.T) {
+	tests := []struct {
+		name         string
+		key          string
+		mirrors      []mirror
+		fingerprints []string
+		strict       bool
+		hosts        []sshHost
+		want         []string
+		err          error
+	}{
+		{
+			name:  "remote",
+			key:   "key",
+			hosts: []sshHost{{Name: "git.example.com", Port: "2222"}},
+		},
+		{
+			name: "mirrors on other hosts",
+			key:  "key",
+			mirrors: []mirror{
+				{URL: "ssh://git@codeberg.org/octo/pages.git", Key: "key"},
+				{URL: "ssh://git@git.example.com:2222/backup/pages.git", Key: "key"},
+				{URL: "https://gitlab.com/octo/pages.git", Password: "token"},
+			},
+			hosts: []sshHost{{Name: "git.example.com", Port: "2222"}, {Name: "codeberg.org", Port: "22"}},
+		},
+		{
+			name:         "only mirrors use keys",
+			mirrors:      []mirror{{URL: "ssh://git@mirror.example.com/octo/pages.git", Key: "key"}},
+			fingerprints: []string{"abc", "SHA256:def"},
+			hosts:        []sshHost{{Name: "mirror.example.com", Port: "22"}},
+			want:         []string{"SHA256:abc", "SHA256:def"},
+		},
+		{
+			name:    "forge fingerprints are trusted for mirrors",
+			mirrors: []mirror{{URL: "ssh://git@codeberg.org/octo/pages.git", Key: "key"}},
+			strict:  true,
+			hosts:   []sshHost{{Name: "codeberg.org", Port: "22"}},
+			want:    forgeFingerprints["codeberg.org"],
+		},
+		{
+			name:    "strict without trusted keys",
+			mirrors: []mirror{{URL: "ssh://git@mirror.example.com/octo/pages.git", Key: "key"}},
+			strict:  true,
+			err:     errConfiguration,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.Key = test.key
+			args.PagesRepo.Mirrors = test.mirrors
+			args.SSH.Fingerprints = test.fingerprints
+			args.SSH.Strict = test.strict
+
+			remote, _ := url.Parse("ssh://git@git.example.com:2222/octo/pages.git")
+
+			err := verifySSH(context.Background(), args, remote)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+
+			if test.err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(args.SSH.Hosts, test.hosts) {
+				t.Errorf("got hosts %v, want %v", args.SSH.Hosts, test.hosts)
+			}
+
+			if test.want != nil && !reflect.DeepEqual(args.SSH.Fingerprints, test.want) {
+				t.Errorf("got fingerprints %v, want %v", args.SSH.Fingerprints, test.want)
+			}
+		})
+	}
+}
+
+func TestLintSSH(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		mirrorKey  string
+		strict     bool
+		passphrase string
+		want       string
+	}{
+		{name: "key without host key checks", key: "key", want: "ssh host keys are not verified"},
+		{name: "mirror key without host key checks", mirrorKey: "key", want: "ssh host keys are not verified"},
+		{name: "host key checks for mirrors", mirrorKey: "key", strict: true},
+		{name: "host key checks without keys", strict: true, want: "have no effect without ssh_key or a mirror ssh_key"},
+		{name: "passphrase for mirror keys", mirrorKey: "key", strict: true, passphrase: "secret", want: "ssh_passphrase has no effect without ssh_key"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.Key = test.key
+			args.SSH.Strict = test.strict
+			args.SSH.Passphrase = test.passphrase
+
+			if test.mirrorKey != "" {
+				args.PagesRepo.Mirrors = mirrorList{{URL: "ssh://git@mirror.example.com/pages.git", Key: test.mirrorKey}}
+			}
+
+			_, warnings := lintArgs(args)
+
+			for _, line := range strings.Split(warnings, "\n") {
+				if strings.Contains(line, "ssh") && (test.want == "" || !strings.Contains(line, test.want)) {
+					t.Errorf("unexpected warning %q", line)
+				}
+			}
+
+			if test.want != "" && !strings.Contains(warnings, test.want) {
+				t.Errorf("warnings %q do not contain %q", warnings, test.want)
+			}
+		})
+	}
+}
+
+// TestMirrorSSHHosts pushes to mirrors on two ssh hosts other than the
+// remote, checking their host keys as configured.
+func TestMirrorSSHHosts(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	mirrorKey, mirrorPublic := sshKeyPair(t)
+	first := newSSHGitServer(t, mirrorPublic)
+	second := newSSHGitServer(t, mirrorPublic)
+
+	tests := []struct {
+		name         string
+		fingerprints []string
+		knownHosts   []*sshGitServer
+		strict       bool
+		pushed       []bool
+	}{
+		{
+			name:   "unknown hosts are accepted without checks",
+			pushed: []bool{true, true},
+		},
+		{
+			name:         "fingerprints pinned for both hosts",
+			fingerprints: []string{first.fingerprint(), strings.TrimPrefix(second.fingerprint(), "SHA256:")},
+			pushed:       []bool{true, true},
+		},
+		{
+			name:       "strict with both hosts known",
+			knownHosts: []*sshGitServer{first, second},
+			strict:     true,
+			pushed:     []bool{true, true},
+		},
+		{
+			name:         "fingerprint pinned for the first host only",
+			fingerprints: []string{first.fingerprint()},
+			pushed:       []bool{true, false},
+		},
+		{
+			name:       "strict with the first host known only",
+			knownHosts: []*sshGitServer{first},
+			strict:     true,
+			pushed:     []bool{true, false},
+		},
+	}
+
+	for _, engine := range engines {
+		for _, test := range tests {
+			t.Run(engine+"/"+test.name, func(t *testing.T) {
+				if engine == engineCLI {
+					for _, tool := range []string{"ssh", "ssh-keyscan"} {
+						if _, err := exec.LookPath(tool); err != nil {
+							t.Skipf("%s not installed", tool)
+						}
+					}
+				}
+
+				remote := newRemote(t)
+				mirrors := []string{newRemote(t), newRemote(t)}
+
+				args := publishTestArgs(t, engine, remote, map[string]string{"index.html": "one"})
+				args.PagesRepo.MirrorPolicy = mirrorPolicyWarn
+				args.SSH.Fingerprints = test.fingerprints
+				args.SSH.Strict = test.strict
+
+				for i, server := range []*sshGitServer{first, second} {
+					args.PagesRepo.Mirrors = append(args.PagesRepo.Mirrors, mirror{
+						Name: fmt.Sprintf("mirror-%d", i+1),
+						URL:  server.url(mirrors[i]),
+						Key:  mirrorKey,
+					})
+				}
+
+				for _, server := range test.knownHosts {
+					args.SSH.KnownHosts += server.knownHost() + "\n"
+				}
+
+				git := testEngine(t, args)
+
+				if err := verifyMirrors(args); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := verifySSH(context.Background(), args, &url.URL{Path: remote}); err != nil {
+					t.Fatal(err)
+				}
+
+				cleanup, err := prepare(context.Background(), args)
+				defer cleanup()
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				results, err := process(context.Background(), args, git)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				tip, err := remoteRef(remote, plumbing.NewBranchReferenceName("gh-pages"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for i, mirror := range mirrors {
+					got, err := remoteRef(mirror, plumbing.NewBranchReferenceName("gh-pages"))
+					pushed := err == nil && got == tip
+
+					if pushed != test.pushed[i] || (results[i+1].Status == remotePushed) != test.pushed[i] {
+						t.Errorf("mirror %d pushed %t with status %s, want %t", i+1, pushed, results[i+1].Status, test.pushed[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+// sshGitServer serves git repositories over ssh to a single key.
+type sshGitServer struct {
+	addr    string
+	hostKey ssh.PublicKey
+}
+
+func newSSHGitServer(t *testing.T, authorized ssh.PublicKey) *sshGitServer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, errors.New("key not authorized")
+			}
+
+			return &ssh.Permissions{}, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveSSH(conn, config)
+		}
+	}()
+
+	return &sshGitServer{addr: listener.Addr().String(), hostKey: signer.PublicKey()}
+}
+
+func (s *sshGitServer) url(path string) string {
+	return "ssh://git@" + s.addr + path
+}
+
+func (s *sshGitServer) fingerprint() string {
+	return ssh.FingerprintSHA256(s.hostKey)
+}
+
+func (s *sshGitServer) knownHost() string {
+	return knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, s.hostKey)
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+
+	server, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer server.Close()
+
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only sessions are served")
+
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go serveGit(channel, requests)
+	}
+}
+
+// serveGit runs the git-upload-pack or git-receive-pack command requested
+// by the client on the session.
+func serveGit(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			_ = req.Reply(req.Type == "env", nil)
+
+			continue
+		}
+
+		payload := struct{ Command string }{}
+		_ = ssh.Unmarshal(req.Payload, &payload)
+
+		service, path, _ := strings.Cut(payload.Command, " ")
+		if service != "git-upload-pack" && service != "git-receive-pack" {
+			_ = req.Reply(false, nil)
+
+			return
+		}
+
+		_ = req.Reply(true, nil)
+
+		cmd := exec.Command("git", strings.TrimPrefix(service, "git-"), strings.Trim(path, "'"))
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+
+		// The client keeps its side open until the command exits
+		stdin, err := cmd.StdinPipe()
+		if err == nil {
+			go func() {
+				_, _ = io.Copy(stdin, channel)
+				stdin.Close()
+			}()
+
+			err = cmd.Run()
+		}
+
+		status := struct{ Status uint32 }{}
+		if err != nil {
+			status.Status = 1
+		}
+
+		_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(&status))
+
+		return
+	}
+}
+
+func sshKeyPair(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+
+	key := sshTestKey(t)
+
+	signer, err := ssh.ParsePrivateKey([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, signer.PublicKey()
+}
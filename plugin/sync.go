@@ -0,0 +1,334 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	syncGo    = "go"
+	syncRsync = "rsync"
+
+	syncCreate = "create"
+	syncUpdate = "update"
+	syncDelete = "delete"
+)
+
+// syncAction is a change made to the destination during sync.
+type syncAction struct {
+	Action string
+	Path   string
+}
+
+func syncPages(ctx context.Context, args *Args) error {
+	filter, err := newSyncFilter(args)
+	if err != nil {
+		return err
+	}
+
+	if args.SyncEngine == syncRsync {
+		return rsyncPages(ctx, args, filter)
+	}
+
+	actions, err := goSyncPages(ctx, args, filter)
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+
+	for _, action := range actions {
+		logrus.Infof("%s %s\n", action.Action, action.Path)
+		counts[action.Action]++
+	}
+
+	logrus.Infof("synced: %d created, %d updated, %d deleted\n", counts[syncCreate], counts[syncUpdate], counts[syncDelete])
+
+	return nil
+}
+
+// goSyncPages mirrors the rsync invocation in process. A source with a
+// trailing slash copies the contents of the directory, otherwise the
+// directory itself is copied into the destination.
+func goSyncPages(ctx context.Context, args *Args, filter *syncFilter) ([]syncAction, error) {
+	src := strings.TrimSuffix(args.Rsync.Source, "/")
+	dst := args.Rsync.Destination
+
+	if !strings.HasSuffix(args.Rsync.Source, "/") {
+		dst = filepath.Join(dst, filepath.Base(src))
+	}
+
+	if err := os.MkdirAll(dst, 0o755); err != nil { //nolint:gomnd
+		return nil, err
+	}
+
+	actions := []syncAction{}
+	seen := map[string]bool{}
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		if filter.excluded(filepath.ToSlash(rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		seen[rel] = true
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
+
+			return os.MkdirAll(target, 0o755) //nolint:gomnd
+		case !d.Type().IsRegular():
+			logrus.Debugf("skipping non-regular file %s\n", rel)
+
+			return nil
+		}
+
+		action, err := syncFile(path, target)
+		if err != nil {
+			return fmt.Errorf("could not copy %s: %w", rel, err)
+		}
+
+		if action != "" {
+			actions = append(actions, syncAction{Action: action, Path: rel})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return actions, err
+	}
+
+	if !args.Rsync.Delete {
+		return actions, nil
+	}
+
+	dirs := []string{}
+
+	err = filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dst, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		// Excluded and protected files are not deleted, matching rsync
+		if filter.excluded(filepath.ToSlash(rel), d.IsDir()) || filter.protected(filepath.ToSlash(rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if seen[rel] {
+			return nil
+		}
+
+		// Directories may hold kept files, so they go once emptied
+		if d.IsDir() {
+			dirs = append(dirs, rel)
+
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+
+		actions = append(actions, syncAction{Action: syncDelete, Path: rel})
+
+		return nil
+	})
+	if err != nil {
+		return actions, err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(filepath.Join(dst, dirs[i]))
+		if err != nil {
+			return actions, err
+		}
+
+		if len(entries) > 0 {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dst, dirs[i])); err != nil {
+			return actions, err
+		}
+
+		actions = append(actions, syncAction{Action: syncDelete, Path: dirs[i]})
+	}
+
+	return actions, nil
+}
+
+// syncFile copies src over dst when the contents differ.
+func syncFile(src, dst string) (string, error) {
+	info, err := os.Lstat(dst)
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return syncCreate, copyFile(src, dst)
+	case err != nil:
+		return "", err
+	case info.IsDir():
+		if err := os.RemoveAll(dst); err != nil {
+			return "", err
+		}
+
+		return syncUpdate, copyFile(src, dst)
+	}
+
+	same, err := sameContents(src, dst)
+	if err != nil || same {
+		return "", err
+	}
+
+	return syncUpdate, copyFile(src, dst)
+}
+
+func sameContents(a, b string) (bool, error) {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+
+	if ai.Size() != bi.Size() {
+		return false, nil
+	}
+
+	af, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer af.Close()
+
+	bf, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer bf.Close()
+
+	abuf := make([]byte, 32*1024) //nolint:gomnd
+	bbuf := make([]byte, 32*1024) //nolint:gomnd
+
+	for {
+		an, aerr := io.ReadFull(af, abuf)
+		bn, berr := io.ReadFull(bf, bbuf)
+
+		if !bytes.Equal(abuf[:an], bbuf[:bn]) {
+			return false, nil
+		}
+
+		if errors.Is(aerr, io.EOF) || errors.Is(aerr, io.ErrUnexpectedEOF) {
+			return errors.Is(berr, io.EOF) || errors.Is(berr, io.ErrUnexpectedEOF), nil
+		}
+
+		if aerr != nil {
+			return false, aerr
+		}
+
+		if berr != nil {
+			return false, berr
+		}
+	}
+}
+
+func rsyncVersion(ctx context.Context, args *Args) error {
+	cmd := exec.CommandContext(
+		ctx,
+		"rsync",
+		"--version",
+	)
+	cmd.Dir = args.PagesRepo.Checkout
+
+	return runCommand(cmd)
+}
+
+func rsyncPages(ctx context.Context, args *Args, filter *syncFilter) error {
+	rysnc := []string{
+		"-r",
+	}
+
+	// Anchored patterns are relative to the transfer root
+	prefix := ""
+	if !strings.HasSuffix(args.Rsync.Source, "/") {
+		prefix = filepath.Base(args.Rsync.Source)
+	}
+
+	rysnc = append(
+		rysnc,
+		filter.rsyncArgs(prefix)...,
+	)
+
+	if args.Rsync.Delete {
+		rysnc = append(
+			rysnc,
+			"--delete",
+		)
+	}
+
+	rysnc = append(
+		rysnc,
+		args.Rsync.Source,
+		args.Rsync.Destination,
+	)
+
+	cmd := exec.CommandContext(
+		ctx,
+		"rsync",
+		rysnc...,
+	)
+
+	return runCommand(cmd)
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGoSyncPages(t *testing.T) {
+	tests := []struct {
+		name         string
+		copyContents bool
+		delete       bool
+		exclude      []string
+		keep         []string
+		seed         []string
+		existing     map[string]string
+		want         map[string]string
+	}{
+		{
+			name:         "contents are copied over the destination",
+			copyContents: true,
+			existing: map[string]string{
+				"index.html": "old",
+				"stale.html": "stale",
+			},
+			want: map[string]string{
+				"index.html":     "index",
+				"guide/a.html":   "guide",
+				"stale.html":     "stale",
+				"drafts/x.html":  "draft",
+				"assets/app.css": "css",
+			},
+		},
+		{
+			name: "the directory is copied without copy_contents",
+			want: map[string]string{
+				"site/index.html":     "index",
+				"site/guide/a.html":   "guide",
+				"site/drafts/x.html":  "draft",
+				"site/assets/app.css": "css",
+			},
+		},
+		{
+			name:         "delete removes stale files but not kept or excluded ones",
+			copyContents: true,
+			delete:       true,
+			exclude:      []string{"/drafts/"},
+			keep:         []string{"CNAME", "/old/archive/"},
+			existing: map[string]string{
+				"CNAME":                   "example.com",
+				"stale.html":              "stale",
+				"old/page.html":           "old",
+				"old/archive/2019.html":   "2019",
+				"gone/deeper/page.html":   "gone",
+				"drafts/wip.html":         "wip",
+				"versions/v1/sub/CNAME":   "nested",
+				"versions/v1/sub/a.html":  "a",
+				"assets/obsolete/old.css": "css",
+			},
+			want: map[string]string{
+				"CNAME":                 "example.com",
+				"index.html":            "index",
+				"guide/a.html":          "guide",
+				"assets/app.css":        "css",
+				"old/archive/2019.html": "2019",
+				"drafts/wip.html":       "wip",
+				"versions/v1/sub/CNAME": "nested",
+			},
+		},
+		{
+			name:         "delete keeps seed files in the branch root",
+			copyContents: true,
+			delete:       true,
+			exclude:      []string{"drafts/"},
+			seed:         []string{"seed/.nojekyll"},
+			existing: map[string]string{
+				".nojekyll":  "",
+				"stale.html": "stale",
+			},
+			want: map[string]string{
+				".nojekyll":      "",
+				"index.html":     "index",
+				"guide/a.html":   "guide",
+				"assets/app.css": "css",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "site")
+			dst := t.TempDir()
+
+			writeFiles(t, src, map[string]string{
+				"index.html":     "index",
+				"guide/a.html":   "guide",
+				"drafts/x.html":  "draft",
+				"assets/app.css": "css",
+			})
+			writeFiles(t, dst, test.existing)
+
+			args := &Args{}
+			args.TargetDirectory = "."
+			args.PagesRepo.Seed = test.seed
+			args.Rsync.Source = src
+			args.Rsync.Destination = dst
+			args.Rsync.Delete = test.delete
+			args.Rsync.Exclude = test.exclude
+			args.Rsync.Keep = test.keep
+
+			if test.copyContents {
+				args.Rsync.Source += "/"
+			}
+
+			filter, err := newSyncFilter(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := goSyncPages(context.Background(), args, filter); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readFiles(t, dst); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGoSyncPagesRemovesEmptiedDirectories(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	writeFiles(t, src, map[string]string{"index.html": "index"})
+	writeFiles(t, dst, map[string]string{
+		"gone/deeper/page.html": "gone",
+		"kept/deeper/CNAME":     "kept",
+	})
+
+	args := &Args{}
+	args.TargetDirectory = "."
+	args.Rsync.Source = src + "/"
+	args.Rsync.Destination = dst
+	args.Rsync.Delete = true
+	args.Rsync.Keep = []string{"CNAME"}
+
+	filter, err := newSyncFilter(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions, err := goSyncPages(context.Background(), args, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := []string{}
+
+	for _, action := range actions {
+		if action.Action == syncDelete {
+			deleted = append(deleted, filepath.ToSlash(action.Path))
+		}
+	}
+
+	want := []string{"gone/deeper/page.html", "gone/deeper", "gone"}
+	if !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted %v, want %v", deleted, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "kept", "deeper")); err != nil {
+		t.Errorf("directory holding a kept file was removed: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const shortLength = 7
+
+// templateFuncs are available to commit message and author templates.
+var templateFuncs = template.FuncMap{
+	"short": func(s string) string {
+		if len(s) > shortLength {
+			return s[:shortLength]
+		}
+
+		return s
+	},
+	"firstLine": func(s string) string {
+		line, _, _ := strings.Cut(s, "\n")
+
+		return strings.TrimSpace(line)
+	},
+	"truncate": func(n int, s string) string {
+		if n >= 0 && len(s) > n {
+			return s[:n]
+		}
+
+		return s
+	},
+	"default": func(def, s string) string {
+		if s == "" {
+			return def
+		}
+
+		return s
+	},
+	"datetime": func(layout string, unix int64) string {
+		return time.Unix(unix, 0).UTC().Format(layout)
+	},
+	"replace": strings.ReplaceAll,
+	"trim":    strings.TrimSpace,
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// renderTemplate renders the text with the pipeline metadata.
+func renderTemplate(name, text string, pipeline *Pipeline) (string, error) {
+	tmpl, err := parseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+
+	if err := tmpl.Execute(&builder, pipeline); err != nil {
+		return "", fmt.Errorf("could not render %s: %w", name, err)
+	}
+
+	return builder.String(), nil
+}
+
+// lintCommit checks the commit settings once the message has been read.
+// Templates that do not render are reported when commit_template is set,
+// settings that look like templates when it is not.
+func lintCommit(args *Args) (issues int, warnings string) {
+	var warningsBuilder strings.Builder
+
+	settings := map[string]string{
+		"message":    args.PagesCommit.Message,
+		"user_name":  args.PagesCommit.Author.Name,
+		"user_email": args.PagesCommit.Author.Email,
+	}
+
+	for _, name := range []string{"message", "user_name", "user_email"} {
+		switch {
+		case args.PagesCommit.Template:
+			if _, err := renderTemplate(name, settings[name], &args.Pipeline); err != nil {
+				warningsBuilder.WriteString(fmt.Sprintf("%s is not a valid template: %s\n", name, err))
+				issues++
+			}
+		case strings.Contains(settings[name], "{{"):
+			warningsBuilder.WriteString(fmt.Sprintf("%s contains {{ but is used as is, set commit_template to `true` to render it\n", name))
+			issues++
+		}
+	}
+
+	return issues, warningsBuilder.String()
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	pipeline := &Pipeline{}
+	pipeline.Build.Number = 7
+	pipeline.Build.Created = 1700000000
+	pipeline.Commit.Rev = "0123456789abcdef"
+	pipeline.Commit.Message = "  fix the docs  \n\nlonger description"
+	pipeline.Commit.Author.Name = "Octo Cat"
+
+	tests := []struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{text: "deploy {{ .Build.Number }}", want: "deploy 7"},
+		{text: "{{ short .Commit.Rev }}", want: "0123456"},
+		{text: "{{ firstLine .Commit.Message }}", want: "fix the docs"},
+		{text: "{{ .Commit.Rev | truncate 4 }}", want: "0123"},
+		{text: "{{ default \"drone\" .Commit.Author.Email }}", want: "drone"},
+		{text: "{{ datetime \"2006-01-02\" .Build.Created }}", want: "2023-11-14"},
+		{text: "{{ replace (lower .Commit.Author.Name) \" \" \"-\" }}", want: "octo-cat"},
+		{text: "{{ .Build.Number", wantErr: true},
+		{text: "{{ .Build.Missing }}", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			got, err := renderTemplate("message", test.text, pipeline)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLintCommit(t *testing.T) {
+	tests := []struct {
+		name     string
+		template bool
+		message  string
+		email    string
+		issues   int
+	}{
+		{
+			name:    "plain settings",
+			message: "publish pages",
+			email:   "drone@example.com",
+		},
+		{
+			name:    "templates without commit_template",
+			message: "deploy {{ .Build.Number }}",
+			email:   "{{ .Commit.Author.Email }}",
+			issues:  2,
+		},
+		{
+			name:     "templates with commit_template",
+			template: true,
+			message:  "deploy {{ .Build.Number }}",
+			email:    "{{ .Commit.Author.Email }}",
+		},
+		{
+			name:     "unterminated template",
+			template: true,
+			message:  "deploy {{ .Build.Number",
+			email:    "drone@example.com",
+			issues:   1,
+		},
+		{
+			name:     "unknown fields",
+			template: true,
+			message:  "deploy {{ .Build.Nummer }}",
+			email:    "{{ .Commit.Author.Mail }}",
+			issues:   2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.PagesCommit.Template = test.template
+			args.PagesCommit.Message = test.message
+			args.PagesCommit.Author.Email = test.email
+
+			if issues, warnings := lintCommit(args); issues != test.issues {
+				t.Errorf("got %d issues, want %d: %s", issues, test.issues, warnings)
+			}
+		})
+	}
+}
@@ -6,14 +6,19 @@
 package plugin
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 //nolint:errcheck
@@ -40,7 +45,7 @@ func writeCardTo(out io.Writer, data []byte) {
 	io.WriteString(out, "\n")
 }
 
-func contents(str string) (string, error) {
+func contents(ctx context.Context, str string) (string, error) {
 	// Check for the empty string
 	if str == "" {
 		return str, nil
@@ -52,7 +57,12 @@ func contents(str string) (string, error) {
 	if u, err := url.Parse(str); err == nil {
 		switch u.Scheme {
 		case "http", "https":
-			res, err := http.Get(str)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, str, nil)
+			if err != nil {
+				return "", err
+			}
+
+			res, err := http.DefaultClient.Do(req)
 			if err != nil {
 				return "", err
 			}
@@ -90,3 +100,112 @@ func contents(str string) (string, error) {
 	// Its a regular string
 	return str, nil
 }
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+
+		return err
+	}
+
+	return out.Close()
+}
+
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755) //nolint:gomnd
+		}
+
+		return copyFile(path, target)
+	})
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// requestJSON sends the body as JSON and decodes a successful response
+// into out, when given.
+func requestJSON(ctx context.Context, method, endpoint string, headers map[string]string, body, out interface{}) error {
+	var payload io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		payload = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, payload)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response: %w", err)
+	}
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s %s returned %s: %s", method, endpoint, res.Status, strings.TrimSpace(string(data)))
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("could not decode response of %s: %w", endpoint, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Paths are only read in full up to this size
+const maxMarkerSize = 1 << 20
+
+var errNotServed = errors.New("site not served")
+
+// deployMarker is written next to the pages so the check can tell the
+// published commit is being served. It leaves out the build number so
+// rebuilding a commit does not change the branch.
+type deployMarker struct {
+	Commit string `json:"commit"`
+	Branch string `json:"branch"`
+}
+
+func newDeployMarker(args *Args) deployMarker {
+	return deployMarker{
+		Commit: args.Commit.Rev,
+		Branch: args.PagesRepo.Branch,
+	}
+}
+
+// writeDeployMarker writes the marker into the target directory.
+func writeDeployMarker(args *Args) error {
+	data, err := json.MarshalIndent(newDeployMarker(args), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(args.Rsync.Destination, args.Verify.Marker)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { //nolint:gomnd
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644) //nolint:gomnd,gosec
+}
+
+// writesDeployMarker reports whether publishes write the marker. Artifact
+// deployments are known to be served once the Pages API reports success.
+func writesDeployMarker(args *Args) bool {
+	return args.Verify.Enabled && args.Verify.Marker != "" && args.DeployMethod == deployMethodBranch
+}
+
+// verifyDeployment polls the site until it serves the marker of this build
+// and every required path, or the verify timeout passes.
+func verifyDeployment(ctx context.Context, args *Args, site *url.URL) error {
+	if !args.Verify.Enabled || args.DryRun || args.Preview.Cleanup {
+		return nil
+	}
+
+	base := site
+	if args.Verify.URL != "" {
+		uri, err := url.Parse(args.Verify.URL)
+		if err != nil {
+			return fmt.Errorf("invalid verify_url: %w", err)
+		}
+
+		base = uri
+	}
+
+	ctx, cancel := phaseContext(ctx, args.Verify.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(args.Verify.Interval)
+	defer ticker.Stop()
+
+	logrus.Infof("verifying deployment at %s\n", base)
+
+	var failure error
+
+	for attempt := 1; ; attempt++ {
+		err := checkDeployment(ctx, args, base, attempt)
+		if err == nil {
+			logrus.Infof("deployment verified at %s\n", base)
+
+			return nil
+		}
+
+		// Report why the site was not served rather than the timeout
+		if ctx.Err() == nil {
+			if failure == nil || err.Error() != failure.Error() {
+				logrus.Infof("waiting for deployment: %s\n", err)
+			}
+
+			failure = err
+		}
+
+		select {
+		case <-ctx.Done():
+			if failure == nil {
+				failure = ctx.Err()
+			}
+
+			return fmt.Errorf("deployment not verified at %s: %w", base, failure)
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkDeployment checks the marker and the required paths once.
+func checkDeployment(ctx context.Context, args *Args, base *url.URL, attempt int) error {
+	if writesDeployMarker(args) {
+		// Bust caches in front of the site
+		marker := subpath(base, args.Verify.Marker)
+		marker.RawQuery = url.Values{"attempt": {strconv.Itoa(attempt)}}.Encode()
+
+		data, err := fetchPath(ctx, marker)
+		if err != nil {
+			return fmt.Errorf("%s: %w", args.Verify.Marker, err)
+		}
+
+		served := deployMarker{}
+		if err := json.Unmarshal(data, &served); err != nil {
+			return fmt.Errorf("%s does not parse: %w", args.Verify.Marker, errNotServed)
+		}
+
+		if served != newDeployMarker(args) {
+			return fmt.Errorf("%s is from commit %s: %w", args.Verify.Marker, served.Commit, errNotServed)
+		}
+	}
+
+	for _, path := range args.Verify.Paths {
+		if _, err := fetchPath(ctx, subpath(base, path)); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// fetchPath returns the content served at the url.
+func fetchPath(ctx context.Context, uri *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("returned %s: %w", res.Status, errNotServed)
+	}
+
+	return io.ReadAll(io.LimitReader(res.Body, maxMarkerSize))
+}
+
+// subpath resolves a path against the site url.
+func subpath(base *url.URL, path string) *url.URL {
+	root := *base
+	if !strings.HasSuffix(root.Path, "/") {
+		root.Path += "/"
+	}
+
+	rel, _ := url.Parse("./" + strings.TrimPrefix(path, "/"))
+
+	return root.ResolveReference(rel)
+}
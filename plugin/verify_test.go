@@ -0,0 +1,174 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyDeployment(t *testing.T) {
+	tests := []struct {
+		name   string
+		served string
+		paths  []string
+		err    error
+	}{
+		{
+			name:   "marker of this commit is served",
+			served: "0123456789abcdef",
+			paths:  []string{"index.html"},
+		},
+		{
+			name:   "marker of an earlier commit is served",
+			served: "fedcba9876543210",
+			err:    errNotServed,
+		},
+		{
+			name:   "required path is missing",
+			served: "0123456789abcdef",
+			paths:  []string{"missing.html"},
+			err:    errNotServed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := verifyTestArgs(t)
+			args.Verify.Paths = test.paths
+
+			// The site serves the marker of the served commit
+			served := *args
+			served.Commit.Rev = test.served
+
+			if err := writeDeployMarker(&served); err != nil {
+				t.Fatal(err)
+			}
+
+			writeFiles(t, args.Rsync.Destination, map[string]string{"index.html": "<html></html>"})
+
+			server := httptest.NewServer(http.FileServer(http.Dir(args.Rsync.Destination)))
+			defer server.Close()
+
+			site, _ := url.Parse(server.URL)
+
+			if err := verifyDeployment(context.Background(), args, site); !errors.Is(err, test.err) {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+// TestVerifyVersionedMarker checks the marker is written where the verify
+// url of a version looks for it.
+func TestVerifyVersionedMarker(t *testing.T) {
+	tests := []struct {
+		name      string
+		publisher string
+		target    string
+	}{
+		{
+			name:      "github",
+			publisher: publisherGitHub,
+		},
+		{
+			name:      "github in a directory",
+			publisher: publisherGitHub,
+			target:    "docs",
+		},
+		{
+			name:      "gitlab",
+			publisher: publisherGitLab,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := verifyTestArgs(t)
+			args.Publisher = test.publisher
+			args.TargetDirectory = test.target
+			args.Versioned.Enabled = true
+			args.Versioned.Version = "v1.2"
+
+			publisher, err := newPublisher(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			publisher.Defaults()
+
+			if err := resolveVersion(args); err != nil {
+				t.Fatal(err)
+			}
+
+			args.Rsync.Destination = filepath.Join(args.PagesRepo.Checkout, args.TargetDirectory)
+
+			if err := writeDeployMarker(args); err != nil {
+				t.Fatal(err)
+			}
+
+			// The host serves the root of the publisher at the site url
+			root := filepath.Join(args.PagesRepo.Checkout, publisher.Root())
+			mux := http.NewServeMux()
+			mux.Handle("/pages/", http.StripPrefix("/pages/", http.FileServer(http.Dir(root))))
+
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			pages, _ := url.Parse(server.URL + "/pages/")
+			site := subpageURL(pages, servedPath(publisher, args.TargetDirectory))
+
+			if err := verifyDeployment(context.Background(), args, site); err != nil {
+				t.Errorf("marker not found at %s: %v", site, err)
+			}
+		})
+	}
+}
+
+func TestSubpath(t *testing.T) {
+	tests := []struct {
+		base string
+		path string
+		want string
+	}{
+		{base: "https://octo.github.io/pages", path: ".deploy.json", want: "https://octo.github.io/pages/.deploy.json"},
+		{base: "https://octo.github.io/pages/v1.2/", path: "/guide/index.html", want: "https://octo.github.io/pages/v1.2/guide/index.html"},
+		{base: "https://docs.example.com", path: "index.html", want: "https://docs.example.com/index.html"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			base, _ := url.Parse(test.base)
+
+			if got := subpath(base, test.path).String(); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func verifyTestArgs(t *testing.T) *Args {
+	t.Helper()
+
+	args := &Args{}
+	args.PagesRepo.Checkout = t.TempDir()
+	args.PagesRepo.Branch = "gh-pages"
+	args.Rsync.Destination = args.PagesRepo.Checkout
+	args.Commit.Rev = "0123456789abcdef"
+	args.DeployMethod = deployMethodBranch
+	args.Verify.Enabled = true
+	args.Verify.Marker = ".deploy.json"
+	args.Verify.Interval = 10 * time.Millisecond
+	args.Verify.Timeout = 200 * time.Millisecond
+
+	return args
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	aliasLatest = "latest"
+	aliasStable = "stable"
+)
+
+// prereleaseVersion matches dotted versions with a semver prerelease, such
+// as v2.0.0-rc.1, but not dated tags like 2023-10.
+var prereleaseVersion = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)+-`)
+
+// versionEntry is a single entry in the versions manifest.
+type versionEntry struct {
+	Version string   `json:"version"`
+	Title   string   `json:"title"`
+	Aliases []string `json:"aliases"`
+}
+
+func resolveVersion(args *Args) error {
+	version := args.Versioned.Version
+
+	if version == "" {
+		switch {
+		case args.Semver.Version != "" && args.Semver.PreRelease != "":
+			version = "v" + args.Semver.Version
+		case args.Semver.Major != "" && args.Semver.Minor != "":
+			version = fmt.Sprintf("v%s.%s", args.Semver.Major, args.Semver.Minor)
+		case args.Tag.Name != "":
+			version = args.Tag.Name
+		default:
+			return fmt.Errorf("version could not be determined from tag: %w", errConfiguration)
+		}
+	}
+
+	if strings.ContainsAny(version, `/\`) || version == "." || version == ".." {
+		return fmt.Errorf("invalid version %s: %w", version, errConfiguration)
+	}
+
+	args.Versioned.Directory = version
+	args.Versioned.Aliases = nil
+
+	// Prereleases never take over the aliases
+	if args.Semver.PreRelease == "" && !isPrerelease(version) {
+		if args.Versioned.Latest {
+			args.Versioned.Aliases = append(args.Versioned.Aliases, aliasLatest)
+		}
+
+		if args.Versioned.Stable {
+			args.Versioned.Aliases = append(args.Versioned.Aliases, aliasStable)
+		}
+	}
+
+	logrus.Infof("publishing version %s\n", version)
+
+	args.TargetDirectory = filepath.Join(args.TargetDirectory, version)
+
+	return nil
+}
+
+func publishVersion(args *Args) error {
+	versionDir := filepath.Join(args.PagesRepo.Checkout, args.TargetDirectory)
+	base := filepath.Dir(versionDir)
+	manifest := filepath.Join(args.PagesRepo.Checkout, args.Versioned.Manifest)
+
+	entries, err := readManifest(manifest)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", args.Versioned.Manifest, err)
+	}
+
+	// Aliases stay with a newer version when an older line is published
+	aliases := args.Versioned.Aliases
+	if len(aliases) > 0 && !newestVersion(entries, args.Versioned.Directory) {
+		logrus.Infof("not moving aliases, %s is older than the newest version\n", args.Versioned.Directory)
+
+		aliases = nil
+	}
+
+	for _, alias := range aliases {
+		aliasDir := filepath.Join(base, alias)
+
+		if err := os.RemoveAll(aliasDir); err != nil {
+			return fmt.Errorf("could not remove alias %s: %w", alias, err)
+		}
+
+		if err := copyTree(versionDir, aliasDir); err != nil {
+			return fmt.Errorf("could not copy version to alias %s: %w", alias, err)
+		}
+
+		logrus.Infof("updated alias %s -> %s\n", alias, args.Versioned.Directory)
+	}
+
+	if err := updateManifest(manifest, entries, args.Versioned.Directory, aliases); err != nil {
+		return fmt.Errorf("could not update %s: %w", args.Versioned.Manifest, err)
+	}
+
+	return nil
+}
+
+// readManifest reads the versions manifest, which is empty before the
+// first version is published.
+func readManifest(path string) ([]versionEntry, error) {
+	entries := []versionEntry{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("could not parse manifest: %w", err)
+	}
+
+	return entries, nil
+}
+
+func writeManifest(path string, entries []versionEntry) error {
+	for i := range entries {
+		if entries[i].Aliases == nil {
+			entries[i].Aliases = []string{}
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return compareVersions(entries[i].Version, entries[j].Version) > 0
+	})
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append(data, '\n'), 0o644) //nolint:gomnd,gosec
+}
+
+func updateManifest(path string, entries []versionEntry, version string, aliases []string) error {
+	found := false
+
+	for i := range entries {
+		// Aliases move to the version being published
+		entries[i].Aliases = removeStrings(entries[i].Aliases, aliases)
+
+		if entries[i].Version == version {
+			entries[i].Aliases = append(entries[i].Aliases, aliases...)
+			found = true
+		}
+	}
+
+	if !found {
+		entries = append(entries, versionEntry{
+			Version: version,
+			Title:   version,
+			Aliases: aliases,
+		})
+	}
+
+	return writeManifest(path, entries)
+}
+
+// dropFromManifest removes the versions from the manifest, if there is one.
+func dropFromManifest(path string, versions []string) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	entries, err := readManifest(path)
+	if err != nil {
+		return err
+	}
+
+	kept := []versionEntry{}
+
+	for _, entry := range entries {
+		if len(removeStrings([]string{entry.Version}, versions)) > 0 {
+			kept = append(kept, entry)
+		}
+	}
+
+	if len(kept) == len(entries) {
+		return nil
+	}
+
+	return writeManifest(path, kept)
+}
+
+// newestVersion reports whether the version is at least as new as every
+// released version in the manifest.
+func newestVersion(entries []versionEntry, version string) bool {
+	for _, entry := range entries {
+		if !isPrerelease(entry.Version) && compareVersions(version, entry.Version) < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isPrerelease reports whether the version carries a semver prerelease.
+func isPrerelease(version string) bool {
+	return prereleaseVersion.MatchString(version)
+}
+
+// compareVersions orders dotted version strings numerically, falling back
+// to a string comparison for non-numeric parts.
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an > bn {
+					return 1
+				}
+
+				return -1
+			}
+		case as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+
+	return len(as) - len(bs)
+}
+
+func removeStrings(list, remove []string) []string {
+	result := []string{}
+
+	for _, item := range list {
+		keep := true
+
+		for _, r := range remove {
+			if item == r {
+				keep = false
+
+				break
+			}
+		}
+
+		if keep {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
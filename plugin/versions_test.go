@@ -0,0 +1,231 @@
+// Copyright (c) 2023, the Drone Plugins project authors.
+// Please see the AUTHORS file for details. All rights reserved.
+// Use of this source code is governed by an Apache 2.0 license that can be
+// found in the LICENSE file.
+
+package plugin
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{a: "v1.10", b: "v1.9", want: 1},
+		{a: "v1.2", b: "v1.2", want: 0},
+		{a: "1.2", b: "v1.3", want: -1},
+		{a: "v2.0", b: "v1.99.1", want: 1},
+		{a: "v1.2.1", b: "v1.2", want: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.a+" "+test.b, func(t *testing.T) {
+			got := compareVersions(test.a, test.b)
+
+			if (got > 0) != (test.want > 0) || (got < 0) != (test.want < 0) {
+				t.Errorf("got %d, want sign of %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    string
+		tag        string
+		major      string
+		minor      string
+		semver     string
+		prerelease string
+		want       string
+		aliases    []string
+		err        error
+	}{
+		{
+			name:    "minor line from the semver tag",
+			tag:     "v1.2.3",
+			major:   "1",
+			minor:   "2",
+			semver:  "1.2.3",
+			want:    "v1.2",
+			aliases: []string{aliasLatest, aliasStable},
+		},
+		{
+			name:       "prereleases keep the full version and no aliases",
+			tag:        "v2.0.0-rc.1",
+			major:      "2",
+			minor:      "0",
+			semver:     "2.0.0-rc.1",
+			prerelease: "rc.1",
+			want:       "v2.0.0-rc.1",
+		},
+		{
+			name:    "configured prerelease version",
+			version: "v3.0-beta",
+			want:    "v3.0-beta",
+		},
+		{
+			name:    "plain tag",
+			tag:     "2023-10",
+			want:    "2023-10",
+			aliases: []string{aliasLatest, aliasStable},
+		},
+		{
+			name: "no tag",
+			err:  errConfiguration,
+		},
+		{
+			name:    "version with a path",
+			version: "../v1",
+			err:     errConfiguration,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &Args{}
+			args.TargetDirectory = "docs"
+			args.Versioned.Version = test.version
+			args.Versioned.Latest = true
+			args.Versioned.Stable = true
+			args.Tag.Name = test.tag
+			args.Semver.Major = test.major
+			args.Semver.Minor = test.minor
+			args.Semver.Version = test.semver
+			args.Semver.PreRelease = test.prerelease
+
+			err := resolveVersion(args)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+
+			if test.err != nil {
+				return
+			}
+
+			if args.Versioned.Directory != test.want || args.TargetDirectory != filepath.Join("docs", test.want) {
+				t.Errorf("got %s in %s, want %s", args.Versioned.Directory, args.TargetDirectory, test.want)
+			}
+
+			if !reflect.DeepEqual(args.Versioned.Aliases, test.aliases) {
+				t.Errorf("got aliases %v, want %v", args.Versioned.Aliases, test.aliases)
+			}
+		})
+	}
+}
+
+func TestPublishVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  string
+		aliases  []string
+		manifest []versionEntry
+		want     []versionEntry
+		latest   string
+	}{
+		{
+			name:    "first version takes the aliases",
+			version: "v1.0",
+			aliases: []string{aliasLatest},
+			want: []versionEntry{
+				{Version: "v1.0", Title: "v1.0", Aliases: []string{aliasLatest}},
+			},
+			latest: "v1.0",
+		},
+		{
+			name:    "newer version moves the aliases",
+			version: "v1.10",
+			aliases: []string{aliasLatest},
+			manifest: []versionEntry{
+				{Version: "v1.9", Title: "v1.9", Aliases: []string{aliasLatest}},
+			},
+			want: []versionEntry{
+				{Version: "v1.10", Title: "v1.10", Aliases: []string{aliasLatest}},
+				{Version: "v1.9", Title: "v1.9", Aliases: []string{}},
+			},
+			latest: "v1.10",
+		},
+		{
+			name:    "older version leaves the aliases",
+			version: "v1.8",
+			aliases: []string{aliasLatest},
+			manifest: []versionEntry{
+				{Version: "v1.9", Title: "v1.9", Aliases: []string{aliasLatest}},
+			},
+			want: []versionEntry{
+				{Version: "v1.9", Title: "v1.9", Aliases: []string{aliasLatest}},
+				{Version: "v1.8", Title: "v1.8", Aliases: []string{}},
+			},
+			latest: "v1.9",
+		},
+		{
+			name:    "newer prereleases do not hold the aliases back",
+			version: "v1.9",
+			aliases: []string{aliasLatest},
+			manifest: []versionEntry{
+				{Version: "v2.0.0-rc.1", Title: "v2.0.0-rc.1", Aliases: []string{}},
+				{Version: "v1.8", Title: "v1.8", Aliases: []string{aliasLatest}},
+			},
+			want: []versionEntry{
+				{Version: "v2.0.0-rc.1", Title: "v2.0.0-rc.1", Aliases: []string{}},
+				{Version: "v1.9", Title: "v1.9", Aliases: []string{aliasLatest}},
+				{Version: "v1.8", Title: "v1.8", Aliases: []string{}},
+			},
+			latest: "v1.9",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkout := t.TempDir()
+
+			for _, entry := range test.manifest {
+				writeFiles(t, checkout, map[string]string{entry.Version + "/index.html": entry.Version})
+
+				for _, alias := range entry.Aliases {
+					writeFiles(t, checkout, map[string]string{alias + "/index.html": entry.Version})
+				}
+			}
+
+			if test.manifest != nil {
+				if err := writeManifest(filepath.Join(checkout, "versions.json"), test.manifest); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			writeFiles(t, checkout, map[string]string{test.version + "/index.html": test.version})
+
+			args := &Args{}
+			args.PagesRepo.Checkout = checkout
+			args.TargetDirectory = test.version
+			args.Versioned.Directory = test.version
+			args.Versioned.Aliases = test.aliases
+			args.Versioned.Manifest = "versions.json"
+
+			if err := publishVersion(args); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readManifest(filepath.Join(checkout, "versions.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got manifest %v, want %v", got, test.want)
+			}
+
+			if served := readFiles(t, checkout)[aliasLatest+"/index.html"]; served != test.latest {
+				t.Errorf("latest serves %s, want %s", served, test.latest)
+			}
+		})
+	}
+}